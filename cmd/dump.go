@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/go-logr/logr"
+	"github.com/synarete/nfs-ganesha-metrics/internal/metrics"
+)
+
+// dump prints NFS-Ganesha stats once and returns the process exit code
+func dump(log logr.Logger, args []string) int {
+	dopts := metrics.DumpOptions{}
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	fs.BoolVar(&dopts.Exports, "exports", false, "Dump exports and their stats")
+	fs.BoolVar(&dopts.Clients, "clients", false, "Dump clients and their stats")
+	fs.BoolVar(&dopts.JSON, "json", false, "Dump in JSON format")
+	_ = fs.Parse(args)
+
+	opts := metrics.NewDefaultOptions()
+	if err := metrics.DumpNfsgStats(os.Stdout, log, opts, dopts); err != nil {
+		log.Error(err, "DumpNfsgStats")
+		return 1
+	}
+	return 0
+}
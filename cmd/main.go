@@ -3,8 +3,13 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"os"
+	"os/signal"
 	goruntime "runtime"
+	"strings"
+	"syscall"
 
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -26,14 +31,192 @@ func init() {
 func main() {
 	log := zap.New(zap.UseDevMode(true))
 
+	// Execute subcommand, if any
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "dump":
+			os.Exit(dump(log, os.Args[2:]))
+		case "top":
+			os.Exit(top(log, os.Args[2:]))
+		case "probe":
+			os.Exit(probe(os.Args[2:]))
+		}
+	}
+
+	// Parse command-line options
+	opts := parseOptions()
+
 	// Report global info early upon boot
 	start(log)
 
-	// Do minimal check for DBus readers
-	poke(log)
+	// Check NFS-Ganesha's DBus interface early upon boot
+	selfCheck(log)
 
 	// Execute metrics server
-	exec(log)
+	exec(log, opts)
+}
+
+func parseOptions() *metrics.Options {
+	opts := metrics.NewDefaultOptions()
+	flag.Func("metrics-addr",
+		"Comma-separated list of addresses on which to serve metrics: "+
+			"host:port or unix:/path[?mode=0660] (default \""+
+			strings.Join(opts.MetricsAddrs, ",")+"\")",
+		replaceList(&opts.MetricsAddrs))
+	flag.StringVar(&opts.WebConfigFile, "web-config-file", opts.WebConfigFile,
+		"Path of TLS and basic-auth configuration file")
+	flag.DurationVar(&opts.ShutdownTimeout, "shutdown-timeout",
+		opts.ShutdownTimeout,
+		"Maximal period to wait for in-flight scrapes upon shutdown")
+	flag.BoolVar(&opts.DebugEndpoints, "debug-endpoints", opts.DebugEndpoints,
+		"Enable /debug/pprof and /debug/dbus HTTP endpoints")
+	flag.BoolVar(&opts.AdminEndpoints, "admin-endpoints", opts.AdminEndpoints,
+		"Enable administrative endpoints (requires basic auth, and TLS or unix socket)")
+	flag.Func("admin-users",
+		"Comma-separated list of basic-auth users allowed to call admin endpoints",
+		appendList(&opts.AdminUsers))
+	flag.DurationVar(&opts.PollInterval, "poll-interval", opts.PollInterval,
+		"Period of background stats polling (zero for on-scrape polling)")
+	flag.IntVar(&opts.MaxConcurrentCalls, "max-concurrent-calls",
+		opts.MaxConcurrentCalls,
+		"Maximal number of in-flight per-export and per-client stats calls")
+	flag.Func("enable-stats",
+		"Comma-separated list of NFS-Ganesha stats types to enable when disabled",
+		appendList(&opts.EnableStats))
+	flag.Func("client-allow-cidrs",
+		"Comma-separated list of CIDRs of clients to export",
+		appendList(&opts.ClientAllowCIDRs))
+	flag.Func("client-deny-cidrs",
+		"Comma-separated list of CIDRs of clients not to export",
+		appendList(&opts.ClientDenyCIDRs))
+	flag.IntVar(&opts.ClientTopN, "client-top-n", opts.ClientTopN,
+		"Export only the N clients with highest throughput (zero for all)")
+	flag.StringVar(&opts.ClientAggregate, "client-aggregate",
+		opts.ClientAggregate,
+		"Aggregation of all allowed clients besides top-n: none, other or cidr")
+	flag.IntVar(&opts.ClientAggregatePrefixV4, "client-aggregate-prefix-v4",
+		opts.ClientAggregatePrefixV4,
+		"Prefix length of IPv4 clients aggregation buckets")
+	flag.IntVar(&opts.ClientAggregatePrefixV6, "client-aggregate-prefix-v6",
+		opts.ClientAggregatePrefixV6,
+		"Prefix length of IPv6 clients aggregation buckets")
+	flag.DurationVar(&opts.ClientIdleThreshold, "client-idle-threshold",
+		opts.ClientIdleThreshold,
+		"Period of inactivity after which a client is counted as idle")
+	flag.BoolVar(&opts.EnrichClientPods, "enrich-client-pods",
+		opts.EnrichClientPods,
+		"Map clients IP addresses to Kubernetes pods")
+	flag.BoolVar(&opts.EnrichClientDNS, "enrich-client-dns",
+		opts.EnrichClientDNS,
+		"Resolve clients hostnames by reverse-DNS lookup")
+	flag.DurationVar(&opts.EnrichClientDNSTTL, "enrich-client-dns-ttl",
+		opts.EnrichClientDNSTTL,
+		"Caching period of clients reverse-DNS lookups")
+	flag.BoolVar(&opts.ResolveExportVolumes, "resolve-export-volumes",
+		opts.ResolveExportVolumes,
+		"Map exports to Kubernetes persistent volumes and claims")
+	flag.Func("export-servers",
+		"Comma-separated list of NFS server addresses of this NFS-Ganesha "+
+			"(default: addresses of own pod and node)",
+		appendList(&opts.ExportServers))
+	flag.BoolVar(&opts.ExportClients, "export-clients", opts.ExportClients,
+		"Export connected clients matching each export's access list")
+	flag.IntVar(&opts.ExportClientsMax, "export-clients-max",
+		opts.ExportClientsMax,
+		"Maximal number of per-export client series")
+	flag.BoolVar(&opts.ExportEvents, "export-events", opts.ExportEvents,
+		"Emit Kubernetes Events upon exports added, removed or changed")
+	flag.BoolVar(&opts.SelfPodInfo, "self-pod-info", opts.SelfPodInfo,
+		"Export metadata of the exporter's own pod")
+	flag.BoolVar(&opts.SelfPodConstLabels, "self-pod-const-labels",
+		opts.SelfPodConstLabels,
+		"Attach the exporter's pod, namespace and node labels to all metrics")
+	flag.Func("self-pod-labels",
+		"Comma-separated list of self pod labels to export",
+		appendList(&opts.SelfPodLabels))
+	flag.Func("self-pod-annotations",
+		"Comma-separated list of self pod annotations to export",
+		appendList(&opts.SelfPodAnnotations))
+	flag.DurationVar(&opts.PushInterval, "push-interval", opts.PushInterval,
+		"Period of pushing metrics to push-based sinks")
+	flag.StringVar(&opts.OTLPEndpoint, "otlp-endpoint", opts.OTLPEndpoint,
+		"Address of OpenTelemetry receiver to push metrics to (disabled if empty)")
+	flag.StringVar(&opts.OTLPProtocol, "otlp-protocol", opts.OTLPProtocol,
+		"OTLP push transport: grpc or http")
+	flag.BoolVar(&opts.OTLPInsecure, "otlp-insecure", opts.OTLPInsecure,
+		"Push OTLP metrics without TLS")
+	flag.Func("otlp-headers",
+		"Comma-separated list of key=value headers of OTLP push requests",
+		appendList(&opts.OTLPHeaders))
+	flag.StringVar(&opts.RemoteWriteURL, "remote-write-url", opts.RemoteWriteURL,
+		"URL of Prometheus remote-write receiver to push metrics to (disabled if empty)")
+	flag.IntVar(&opts.RemoteWriteRetries, "remote-write-retries", opts.RemoteWriteRetries,
+		"Number of retries of a failed remote-write request")
+	flag.IntVar(&opts.RemoteWriteBufferSize, "remote-write-buffer-size",
+		opts.RemoteWriteBufferSize,
+		"Maximal number of unsent remote-write requests to keep in memory")
+	flag.StringVar(&opts.PushgatewayURL, "pushgateway-url", opts.PushgatewayURL,
+		"URL of Prometheus Pushgateway to push metrics to (disabled if empty)")
+	flag.StringVar(&opts.PushgatewayJob, "pushgateway-job", opts.PushgatewayJob,
+		"Job label of Pushgateway grouping key")
+	flag.StringVar(&opts.PushgatewayInstance, "pushgateway-instance",
+		opts.PushgatewayInstance,
+		"Instance label of Pushgateway grouping key (defaults to pod or host name)")
+	flag.StringVar(&opts.TextfileDir, "textfile-dir", opts.TextfileDir,
+		"Directory of node_exporter textfile collector to write metrics into; "+
+			"disables the metrics server unless metrics-addr is given")
+	flag.StringVar(&opts.TextfileName, "textfile-name", opts.TextfileName,
+		"Name of metrics file within textfile directory")
+	flag.BoolVar(&opts.TextfileOneshot, "textfile-oneshot", opts.TextfileOneshot,
+		"Write metrics into textfile directory once and exit")
+	flag.StringVar(&opts.StatsdAddr, "statsd-addr", opts.StatsdAddr,
+		"UDP address of DogStatsD agent to push metrics to (disabled if empty)")
+	flag.Func("statsd-tags",
+		"Comma-separated list of key:value tags of all DogStatsD metrics",
+		appendList(&opts.StatsdTags))
+	flag.StringVar(&opts.InfluxURL, "influxdb-url", opts.InfluxURL,
+		"InfluxDB write URL or udp://host:port to push metrics to (disabled if empty)")
+	flag.StringVar(&opts.InfluxToken, "influxdb-token", opts.InfluxToken,
+		"Authorization token of InfluxDB write API")
+	flag.Parse()
+
+	// Textfile output replaces the metrics server, unless asked for both
+	if opts.TextfileDir != "" && !isFlagSet("metrics-addr") {
+		opts.MetricsAddrs = nil
+	}
+	return opts
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func replaceList(list *[]string) func(string) error {
+	replaced := false
+	return func(s string) error {
+		if !replaced {
+			*list = []string{}
+			replaced = true
+		}
+		return appendList(list)(s)
+	}
+}
+
+func appendList(list *[]string) func(string) error {
+	return func(s string) error {
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				*list = append(*list, v)
+			}
+		}
+		return nil
+	}
 }
 
 func start(log logr.Logger) {
@@ -55,26 +238,20 @@ func start(log logr.Logger) {
 	)
 }
 
-func poke(log logr.Logger) {
-	exportsReader := metrics.NewExportsDbusReader()
-	if err := exportsReader.Setup(); err != nil {
-		log.Error(err, "ExportsDbusReader.Setup")
-		return
-	}
-	defer exportsReader.Close()
+func exec(log logr.Logger, opts *metrics.Options) {
+	ctx, stop := signal.NotifyContext(context.Background(),
+		syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
 
-	clientsReader := metrics.NewClientsDbusReader()
-	if err := exportsReader.Setup(); err != nil {
-		log.Error(err, "ClientsDbusReader.Setup")
-		return
+	run, name := metrics.RunNfsgMetricsExporter, "RunNfsgMetricsExporter"
+	if opts.TextfileOneshot {
+		run, name = metrics.WriteNfsgMetricsTextfile, "WriteNfsgMetricsTextfile"
 	}
-	defer clientsReader.Close()
-}
-
-func exec(log logr.Logger) {
-	err := metrics.RunNfsgMetricsExporter(log)
+	err := run(ctx, log, opts)
 	if err != nil {
-		log.Error(err, "RunNfsgMetricsExporter")
+		log.Error(err, name)
+		stop()
 		os.Exit(1)
 	}
+	log.Info("Exit nfsganeshametrics")
 }
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/go-logr/logr"
+	"github.com/synarete/nfs-ganesha-metrics/internal/metrics"
+)
+
+// probe checks NFS-Ganesha's DBus interface, prints a report and returns
+// the process exit code, for init containers and exec probes
+func probe(args []string) int {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	quiet := fs.Bool("quiet", false, "Print nothing; report by exit code only")
+	requireStats := fs.Bool("require-stats", false,
+		"Fail if NFS stats are disabled, instead of warning")
+	_ = fs.Parse(args)
+
+	report := metrics.ProbeNfsGanesha(*requireStats)
+	if !*quiet {
+		_ = report.Print(os.Stdout)
+	}
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
+
+// selfCheck logs the results of NFS-Ganesha checks; failures are not fatal
+// as NFS-Ganesha may become available later on
+func selfCheck(log logr.Logger) {
+	report := metrics.ProbeNfsGanesha(false)
+	for _, chk := range report.Checks {
+		if chk.OK && chk.Warning {
+			log.Info("Self-check warning", "check", chk.Name, "detail", chk.Detail)
+		} else if chk.OK {
+			log.Info("Self-check", "check", chk.Name, "detail", chk.Detail)
+		} else {
+			log.Error(errors.New(chk.Detail), "Self-check", "check", chk.Name)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-logr/logr"
+	"github.com/synarete/nfs-ganesha-metrics/internal/metrics"
+)
+
+// top runs live terminal view of stats rates and returns the process exit
+// code. Logging is muted while the view owns the terminal
+func top(log logr.Logger, args []string) int {
+	topts := metrics.TopOptions{}
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	fs.DurationVar(&topts.Interval, "interval", metrics.DefaultTopInterval,
+		"Period of stats polling and screen refresh")
+	fs.BoolVar(&topts.Exports, "exports", false, "Start with exports view")
+	fs.StringVar(&topts.CIDR, "cidr", "", "Show only clients within this network")
+	fs.StringVar(&topts.Path, "path", "", "Show only exports with this path prefix")
+	_ = fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(),
+		syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	opts := metrics.NewDefaultOptions()
+	if err := metrics.RunNfsgTop(ctx, logr.Discard(), opts, topts); err != nil {
+		log.Error(err, "RunNfsgTop")
+		return 1
+	}
+	return 0
+}
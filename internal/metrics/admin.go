@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// DefaultAdminStatsResetPath is the HTTP path of stats reset endpoint
+	DefaultAdminStatsResetPath = "/admin/stats/reset"
+
+	errAdminNoAuth  = errors.New("admin endpoints require basic_auth_users in web config")
+	errAdminNoUsers = errors.New("admin endpoints require admin users")
+	errAdminNoTLS   = errors.New("admin endpoints require TLS or a unix socket listener")
+)
+
+// nfsgAdminAudit records administrative actions, per user
+type nfsgAdminAudit struct {
+	mu        sync.Mutex
+	resets    map[string]uint64
+	lastReset time.Time
+}
+
+func newNfsgAdminAudit() *nfsgAdminAudit {
+	return &nfsgAdminAudit{resets: map[string]uint64{}}
+}
+
+func (aa *nfsgAdminAudit) recordReset(user string) {
+	aa.mu.Lock()
+	defer aa.mu.Unlock()
+	aa.resets[user]++
+	aa.lastReset = time.Now()
+}
+
+// checkAdmin verifies that admin endpoints, when enabled, are protected by
+// basic authentication, limited to admin users and reachable without
+// sending credentials in cleartext over the network
+func (nme *nfsgMetricsExporter) checkAdmin() error {
+	if !nme.opts.AdminEndpoints {
+		return nil
+	}
+	cfg, _ := nme.wgd.config()
+	if len(cfg.BasicAuthUsers) == 0 {
+		return errAdminNoAuth
+	}
+	if len(nme.opts.AdminUsers) == 0 {
+		return errAdminNoUsers
+	}
+	if !cfg.hasTLS() && !hasUnixListenAddr(nme.opts.MetricsAddrs) {
+		return errAdminNoTLS
+	}
+	return nil
+}
+
+func hasUnixListenAddr(addrs []string) bool {
+	for _, addr := range addrs {
+		if la, err := parseListenAddr(addr); err == nil && la.network == "unix" {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecureTransport returns true if a request arrived over TLS or over a
+// unix socket, so that its credentials did not cross the network in cleartext
+func isSecureTransport(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+func (nme *nfsgMetricsExporter) isAdminUser(user string) bool {
+	for _, admin := range nme.opts.AdminUsers {
+		if admin == user {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAdminRequest rejects requests which a browser may send cross-site
+// without a preflight: those of form content types, or with an Origin other
+// than the requested host
+func checkAdminRequest(r *http.Request) (int, error) {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return http.StatusUnsupportedMediaType, err
+		}
+		switch mediaType {
+		case "application/x-www-form-urlencoded", "multipart/form-data", "text/plain":
+			return http.StatusUnsupportedMediaType,
+				fmt.Errorf("unsupported content type: %s", mediaType)
+		}
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return http.StatusForbidden, fmt.Errorf("cross-origin request: %s", origin)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+func (nme *nfsgMetricsExporter) handleAdmin() {
+	if !nme.opts.AdminEndpoints {
+		return
+	}
+	nme.mux.HandleFunc(DefaultAdminStatsResetPath, nme.serveAdminStatsReset)
+}
+
+func (nme *nfsgMetricsExporter) serveAdminStatsReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+			http.StatusMethodNotAllowed)
+		return
+	}
+	// web config may have been reloaded without users since startup
+	if err := nme.checkAdmin(); err != nil {
+		nme.writeJSON(w, http.StatusForbidden, &APIError{Error: err.Error()})
+		return
+	}
+	if !isSecureTransport(r) {
+		nme.writeJSON(w, http.StatusForbidden, &APIError{Error: errAdminNoTLS.Error()})
+		return
+	}
+	if code, err := checkAdminRequest(r); err != nil {
+		nme.writeJSON(w, code, &APIError{Error: err.Error()})
+		return
+	}
+	user, _, _ := r.BasicAuth()
+	if !nme.isAdminUser(user) {
+		nme.log.Info("audit: stats reset denied", "user", user, "remote", r.RemoteAddr)
+		nme.writeJSON(w, http.StatusForbidden,
+			&APIError{Error: "user is not an admin user"})
+		return
+	}
+	reply, err := resetStats()
+	if err != nil {
+		nme.log.Error(err, "audit: stats reset failed",
+			"user", user, "remote", r.RemoteAddr)
+		nme.writeJSON(w, http.StatusServiceUnavailable, &APIError{Error: err.Error()})
+		return
+	}
+	nme.log.Info("audit: stats reset", "user", user, "remote", r.RemoteAddr,
+		"status", reply.Status, "error", reply.Error)
+	code := http.StatusOK
+	if reply.Status {
+		nme.aud.recordReset(user)
+	} else {
+		code = http.StatusBadGateway
+	}
+	nme.writeJSON(w, code, reply)
+}
+
+// resetStats calls ResetStats over a dedicated DBus connection, apart from
+// the snapshotter's ones
+func resetStats() (*ReplyHeader, error) {
+	reader := NewExportsDbusReader()
+	if err := reader.Setup(); err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	reply, _, err := reader.ResetStats()
+	return reply, err
+}
+
+// nfsgAdminCollector exports the audit record of administrative actions
+type nfsgAdminCollector struct {
+	nfsgCollector
+}
+
+func (col *nfsgAdminCollector) Collect(ch chan<- prometheus.Metric) {
+	aa := col.nme.aud
+	aa.mu.Lock()
+	defer aa.mu.Unlock()
+	for user, n := range aa.resets {
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[0], prometheus.CounterValue, float64(n), user)
+	}
+	if !aa.lastReset.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[1], prometheus.GaugeValue, float64(aa.lastReset.UnixNano())/1e9)
+	}
+}
+
+func (nme *nfsgMetricsExporter) newNfsgAdminCollector() prometheus.Collector {
+	col := &nfsgAdminCollector{}
+	col.nme = nme
+	col.name = "admin"
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("admin", "stats_resets_total"),
+			"Total number of stats resets via admin endpoint", []string{"user"}, nil),
+		prometheus.NewDesc(
+			collectorName("admin", "last_stats_reset_timestamp_seconds"),
+			"Time of the last stats reset via admin endpoint", []string{}, nil),
+	}
+	return col
+}
@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// DefaultAPIPrefix is the HTTP path prefix of JSON stats API
+	DefaultAPIPrefix = "/api/v1"
+)
+
+// APIExport is a single export with its stats, as returned by JSON API
+type APIExport struct {
+	Export
+	Stats *OperationsStats `json:",omitempty"`
+}
+
+// APIExports is the reply of exports JSON API
+type APIExports struct {
+	SnapshotTime time.Time
+	Time         unix.Timespec
+	Exports      []APIExport
+}
+
+// APIClient is a single client with its stats, as returned by JSON API
+type APIClient struct {
+	Client
+	IOs *ClientIOs `json:",omitempty"`
+}
+
+// APIClients is the reply of clients JSON API
+type APIClients struct {
+	SnapshotTime time.Time
+	Time         unix.Timespec
+	Clients      []APIClient
+}
+
+// APIError is the reply of JSON API upon failure
+type APIError struct {
+	Error string
+}
+
+func (nme *nfsgMetricsExporter) handleAPI() {
+	nme.mux.HandleFunc(DefaultAPIPrefix+"/exports", nme.serveAPIExports)
+	nme.mux.HandleFunc(DefaultAPIPrefix+"/clients", nme.serveAPIClients)
+	nme.mux.HandleFunc(DefaultAPIPrefix+"/clients/", nme.serveAPIClient)
+}
+
+func (nme *nfsgMetricsExporter) serveAPIExports(w http.ResponseWriter, r *http.Request) {
+	if !isAPIMethod(w, r) {
+		return
+	}
+	snap := nme.snr.snapshot()
+	if snap.exportsErr != nil {
+		nme.writeJSON(w, http.StatusServiceUnavailable,
+			&APIError{Error: snap.exportsErr.Error()})
+		return
+	}
+	nme.writeJSON(w, http.StatusOK, makeAPIExports(snap))
+}
+
+func (nme *nfsgMetricsExporter) serveAPIClients(w http.ResponseWriter, r *http.Request) {
+	if !isAPIMethod(w, r) {
+		return
+	}
+	snap := nme.snr.snapshot()
+	if snap.clientsErr != nil {
+		nme.writeJSON(w, http.StatusServiceUnavailable,
+			&APIError{Error: snap.clientsErr.Error()})
+		return
+	}
+	nme.writeJSON(w, http.StatusOK, makeAPIClients(snap, "", nme.clf))
+}
+
+func (nme *nfsgMetricsExporter) serveAPIClient(w http.ResponseWriter, r *http.Request) {
+	if !isAPIMethod(w, r) {
+		return
+	}
+	ipaddr := strings.TrimPrefix(r.URL.Path, DefaultAPIPrefix+"/clients/")
+	if net.ParseIP(ipaddr) == nil {
+		nme.writeJSON(w, http.StatusBadRequest,
+			&APIError{Error: "illegal client address: " + ipaddr})
+		return
+	}
+	snap := nme.snr.snapshot()
+	if snap.clientsErr != nil {
+		nme.writeJSON(w, http.StatusServiceUnavailable,
+			&APIError{Error: snap.clientsErr.Error()})
+		return
+	}
+	reply := makeAPIClients(snap, ipaddr, nme.clf)
+	if len(reply.Clients) == 0 {
+		nme.writeJSON(w, http.StatusNotFound,
+			&APIError{Error: "unknown client: " + ipaddr})
+		return
+	}
+	nme.writeJSON(w, http.StatusOK, &reply.Clients[0])
+}
+
+func isAPIMethod(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD")
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+		http.StatusMethodNotAllowed)
+	return false
+}
+
+func (nme *nfsgMetricsExporter) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		nme.log.Error(err, "failed to encode JSON reply")
+	}
+}
+
+func makeAPIExports(snap *nfsgSnapshot) *APIExports {
+	reply := &APIExports{
+		SnapshotTime: snap.time,
+		Time:         snap.exportsTime,
+		Exports:      make([]APIExport, 0, len(snap.exports)),
+	}
+	for _, ent := range snap.exports {
+		reply.Exports = append(reply.Exports, APIExport{
+			Export: ent.export,
+			Stats:  ent.stats,
+		})
+	}
+	return reply
+}
+
+// makeAPIClients converts snapshot's clients into JSON API reply, optionally
+// limited to a single client by its IP address. When a clients filter is
+// given, clients which it does not allow are omitted
+func makeAPIClients(snap *nfsgSnapshot, ipaddr string,
+	clf *nfsgClientFilter) *APIClients {
+	reply := &APIClients{
+		SnapshotTime: snap.time,
+		Time:         snap.clientsTime,
+		Clients:      make([]APIClient, 0, len(snap.clients)),
+	}
+	for _, ent := range snap.clients {
+		if ipaddr != "" && !sameIP(ent.client.Client, ipaddr) {
+			continue
+		}
+		if clf != nil && !clf.allowed(ent.client.Client) {
+			continue
+		}
+		reply.Clients = append(reply.Clients, APIClient{
+			Client: ent.client,
+			IOs:    ent.ios,
+		})
+	}
+	return reply
+}
+
+func sameIP(a, b string) bool {
+	ipa := net.ParseIP(a)
+	ipb := net.ParseIP(b)
+	if ipa == nil || ipb == nil {
+		return a == b
+	}
+	return ipa.Equal(ipb)
+}
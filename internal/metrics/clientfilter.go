@@ -0,0 +1,271 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// ClientAggregateNone exports each client as a distinct series
+	ClientAggregateNone = "none"
+	// ClientAggregateOther folds all allowed clients into a single series
+	// labelled "all", in addition to the top-N clients series
+	ClientAggregateOther = "other"
+	// ClientAggregateCIDR folds all allowed clients into per-CIDR series, in
+	// addition to the top-N clients series
+	ClientAggregateCIDR = "cidr"
+
+	clientAllLabel   = "all"
+	clientOtherLabel = "other"
+)
+
+// nfsgClientFilter limits the cardinality of per-client series: it drops
+// clients by allow/deny CIDR lists, selects the top-N clients by throughput
+// and aggregates all allowed clients into "all" or per-CIDR buckets. The
+// membership of aggregate buckets does not depend on the top-N ranking, so
+// that they only decrease when clients go away; as they overlap the top-N
+// series, they should not be summed with them
+type nfsgClientFilter struct {
+	allow     []*net.IPNet
+	deny      []*net.IPNet
+	topN      int
+	aggregate string
+	prefixV4  int
+	prefixV6  int
+	mu        sync.Mutex
+	lastSnap  *nfsgSnapshot
+	lastOut   []nfsgClientEntry
+	prevTime  time.Time
+	prevBytes map[string]uint64
+}
+
+func newNfsgClientFilter(opts *Options) (*nfsgClientFilter, error) {
+	allow, err := parseCIDRs(opts.ClientAllowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseCIDRs(opts.ClientDenyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	switch opts.ClientAggregate {
+	case "", ClientAggregateNone, ClientAggregateOther, ClientAggregateCIDR:
+	default:
+		return nil, fmt.Errorf("illegal client aggregation: %s",
+			opts.ClientAggregate)
+	}
+	if opts.ClientAggregate == ClientAggregateOther && opts.ClientTopN <= 0 {
+		return nil, fmt.Errorf("client aggregation %s requires top-n clients",
+			opts.ClientAggregate)
+	}
+	if opts.ClientAggregatePrefixV4 < 0 || opts.ClientAggregatePrefixV4 > 32 {
+		return nil, fmt.Errorf("illegal IPv4 prefix length: %d",
+			opts.ClientAggregatePrefixV4)
+	}
+	if opts.ClientAggregatePrefixV6 < 0 || opts.ClientAggregatePrefixV6 > 128 {
+		return nil, fmt.Errorf("illegal IPv6 prefix length: %d",
+			opts.ClientAggregatePrefixV6)
+	}
+	return &nfsgClientFilter{
+		allow:     allow,
+		deny:      deny,
+		topN:      opts.ClientTopN,
+		aggregate: opts.ClientAggregate,
+		prefixV4:  opts.ClientAggregatePrefixV4,
+		prefixV6:  opts.ClientAggregatePrefixV6,
+		prevBytes: map[string]uint64{},
+	}, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	ret := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, ipnet)
+	}
+	return ret, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the clients entries of a snapshot which should be exported,
+// possibly with aggregated entries. Results are cached per snapshot, so
+// that concurrent scrapes of the same snapshot are consistent
+func (cf *nfsgClientFilter) apply(snap *nfsgSnapshot) []nfsgClientEntry {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	if cf.lastSnap == snap {
+		return cf.lastOut
+	}
+	out := cf.choose(snap)
+	cf.lastSnap = snap
+	cf.lastOut = out
+	return out
+}
+
+func (cf *nfsgClientFilter) choose(snap *nfsgSnapshot) []nfsgClientEntry {
+	allowed := make([]nfsgClientEntry, 0, len(snap.clients))
+	for _, ent := range snap.clients {
+		if ent.ios != nil && cf.allowed(ent.client.Client) {
+			allowed = append(allowed, ent)
+		}
+	}
+	rates := cf.throughput(snap.time, allowed)
+	if cf.topN <= 0 {
+		if cf.aggregate == ClientAggregateCIDR {
+			return cf.aggregateByCIDR(nil, allowed)
+		}
+		return allowed
+	}
+
+	ranked := make([]nfsgClientEntry, len(allowed))
+	copy(ranked, allowed)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		ri := rates[ranked[i].client.Client]
+		rj := rates[ranked[j].client.Client]
+		if ri != rj {
+			return ri > rj
+		}
+		return ranked[i].client.Client < ranked[j].client.Client
+	})
+	top := ranked
+	if len(top) > cf.topN {
+		top = ranked[:cf.topN]
+	}
+	switch cf.aggregate {
+	case ClientAggregateOther:
+		return append(top, aggregateClients(clientAllLabel, allowed))
+	case ClientAggregateCIDR:
+		return cf.aggregateByCIDR(top, allowed)
+	}
+	return top
+}
+
+func (cf *nfsgClientFilter) allowed(ipaddr string) bool {
+	if len(cf.allow) == 0 && len(cf.deny) == 0 {
+		return true
+	}
+	ip := net.ParseIP(ipaddr)
+	if ip == nil {
+		return len(cf.allow) == 0
+	}
+	if containsIP(cf.deny, ip) {
+		return false
+	}
+	return len(cf.allow) == 0 || containsIP(cf.allow, ip)
+}
+
+// throughput computes per-client transferred bytes per second since the
+// previous snapshot. Newly seen clients and clients whose counters were reset
+// rank as zero until two samples exist
+func (cf *nfsgClientFilter) throughput(
+	now time.Time, ents []nfsgClientEntry) map[string]float64 {
+	rates := make(map[string]float64, len(ents))
+	bytes := make(map[string]uint64, len(ents))
+	dt := now.Sub(cf.prevTime).Seconds()
+	for _, ent := range ents {
+		ipaddr := ent.client.Client
+		cur := transferredBytes(&ent.ios.ClientIOStats)
+		prev, ok := cf.prevBytes[ipaddr]
+		if ok && cur >= prev && dt > 0 {
+			rates[ipaddr] = float64(cur-prev) / dt
+		} else {
+			rates[ipaddr] = 0
+		}
+		bytes[ipaddr] = cur
+	}
+	cf.prevTime = now
+	cf.prevBytes = bytes
+	return rates
+}
+
+func (cf *nfsgClientFilter) aggregateByCIDR(
+	top, all []nfsgClientEntry) []nfsgClientEntry {
+	buckets := map[string][]nfsgClientEntry{}
+	keys := []string{}
+	for _, ent := range all {
+		key := cf.bucketOf(ent.client.Client)
+		if _, ok := buckets[key]; !ok {
+			keys = append(keys, key)
+		}
+		buckets[key] = append(buckets[key], ent)
+	}
+	sort.Strings(keys)
+	out := append([]nfsgClientEntry{}, top...)
+	for _, key := range keys {
+		out = append(out, aggregateClients(key, buckets[key]))
+	}
+	return out
+}
+
+func (cf *nfsgClientFilter) bucketOf(ipaddr string) string {
+	ip := net.ParseIP(ipaddr)
+	if ip == nil {
+		return clientOtherLabel
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(cf.prefixV4, 32)
+		return (&net.IPNet{IP: ip4.Mask(mask), Mask: mask}).String()
+	}
+	mask := net.CIDRMask(cf.prefixV6, 128)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+// aggregateClients folds multiple clients entries into a single entry
+func aggregateClients(label string, ents []nfsgClientEntry) nfsgClientEntry {
+	agg := nfsgClientEntry{
+		client: Client{Client: label},
+		ios:    &ClientIOs{},
+	}
+	agg.ios.Status = true
+	for _, ent := range ents {
+		cl := &ent.client
+		agg.client.NFSv3 = agg.client.NFSv3 || cl.NFSv3
+		agg.client.NFSv40 = agg.client.NFSv40 || cl.NFSv40
+		agg.client.NFSv41 = agg.client.NFSv41 || cl.NFSv41
+		agg.client.NFSv42 = agg.client.NFSv42 || cl.NFSv42
+		agg.client.LastTime = laterTimespec(agg.client.LastTime, cl.LastTime)
+		addIOStats(&agg.ios.NFSv3, &ent.ios.NFSv3)
+		addIOStats(&agg.ios.NFSv40, &ent.ios.NFSv40)
+		addIOStats(&agg.ios.NFSv41, &ent.ios.NFSv41)
+		addIOStats(&agg.ios.NFSv42, &ent.ios.NFSv42)
+	}
+	return agg
+}
+
+func addIOStats(dst, src *IOStats) {
+	addIOCounts(&dst.Read, &src.Read)
+	addIOCounts(&dst.Write, &src.Write)
+	addIOCounts(&dst.Other, &src.Other)
+	addIOCounts(&dst.Layout, &src.Layout)
+}
+
+func addIOCounts(dst, src *IOCounts) {
+	dst.Total += src.Total
+	dst.Errors += src.Errors
+	dst.Transferred += src.Transferred
+}
+
+func transferredBytes(ios *ClientIOStats) uint64 {
+	sum := uint64(0)
+	for _, st := range []*IOStats{&ios.NFSv3, &ios.NFSv40, &ios.NFSv41, &ios.NFSv42} {
+		sum += st.Read.Transferred + st.Write.Transferred
+	}
+	return sum
+}
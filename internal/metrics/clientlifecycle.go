@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+// nfsgClientTracker diffs client sets between snapshots, and counts clients
+// connected to or disconnected from NFS-Ganesha
+type nfsgClientTracker struct {
+	nme         *nfsgMetricsExporter
+	mu          sync.Mutex
+	present     map[string]bool
+	baseline    bool
+	connects    uint64
+	disconnects uint64
+}
+
+func newNfsgClientTracker(nme *nfsgMetricsExporter) *nfsgClientTracker {
+	return &nfsgClientTracker{nme: nme, present: map[string]bool{}}
+}
+
+// observe diffs the clients of a successful snapshot against the previous
+// one. The first snapshot is a baseline, which counts no churn
+func (clt *nfsgClientTracker) observe(snap *nfsgSnapshot) {
+	if snap.clientsErr != nil {
+		return
+	}
+	clt.mu.Lock()
+	defer clt.mu.Unlock()
+
+	present := make(map[string]bool, len(snap.clients))
+	for _, ent := range snap.clients {
+		ipaddr := ent.client.Client
+		present[ipaddr] = true
+		if clt.baseline && !clt.present[ipaddr] {
+			clt.connects++
+			clt.nme.log.V(1).Info("client connected", "ipaddr", ipaddr)
+		}
+	}
+	for ipaddr := range clt.present {
+		if !present[ipaddr] {
+			clt.disconnects++
+			clt.nme.log.V(1).Info("client disconnected", "ipaddr", ipaddr)
+		}
+	}
+	clt.present = present
+	clt.baseline = true
+}
+
+// lastActivity returns the time of a client's most recent activity, or zero
+// time if unknown
+func lastActivity(cl *Client) time.Time {
+	if cl.LastTime.Sec == 0 && cl.LastTime.Nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(cl.LastTime.Sec, cl.LastTime.Nsec)
+}
+
+// laterTimespec returns the later of two timestamps
+func laterTimespec(a, b unix.Timespec) unix.Timespec {
+	if b.Sec > a.Sec || (b.Sec == a.Sec && b.Nsec > a.Nsec) {
+		return b
+	}
+	return a
+}
+
+// nfsgClientLifecycleCollector exports the activity and churn of
+// NFS-Ganesha clients
+type nfsgClientLifecycleCollector struct {
+	nfsgCollector
+}
+
+func (col *nfsgClientLifecycleCollector) Collect(ch chan<- prometheus.Metric) {
+	clt := col.nme.snr.clt
+	clt.mu.Lock()
+	connects, disconnects := clt.connects, clt.disconnects
+	clt.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[0], prometheus.CounterValue, float64(connects))
+
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[1], prometheus.CounterValue, float64(disconnects))
+
+	snap := col.nme.snr.snapshot()
+	if snap.clientsErr != nil {
+		return
+	}
+	idle := 0
+	for _, ent := range snap.clients {
+		last := lastActivity(&ent.client)
+		if !last.IsZero() && snap.time.Sub(last) > col.nme.opts.ClientIdleThreshold {
+			idle++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[2], prometheus.GaugeValue, float64(idle))
+
+	for _, ent := range col.nme.clf.apply(snap) {
+		last := lastActivity(&ent.client)
+		if last.IsZero() {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[3], prometheus.GaugeValue,
+			float64(last.UnixNano())/1e9, ent.client.Client)
+	}
+}
+
+func (nme *nfsgMetricsExporter) newNfsgClientLifecycleCollector() prometheus.Collector {
+	col := &nfsgClientLifecycleCollector{}
+	col.nme = nme
+	col.name = "client_lifecycle"
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("client", "connects_total"),
+			"Total number of clients connected", []string{}, nil),
+		prometheus.NewDesc(
+			collectorName("client", "disconnects_total"),
+			"Total number of clients disconnected", []string{}, nil),
+		prometheus.NewDesc(
+			collectorName("client", "idle_count"),
+			"Number of NFS clients idle longer than threshold", []string{}, nil),
+		prometheus.NewDesc(
+			collectorName("client", "last_activity_timestamp_seconds"),
+			"Time of client's most recent activity", []string{"ipaddr"}, nil),
+	}
+	return col
+}
@@ -3,7 +3,9 @@
 package metrics
 
 import (
+	"net"
 	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -17,12 +19,39 @@ func (nme *nfsgMetricsExporter) register() error {
 		nme.newNfsgVersionsCollector(),
 		nme.newNfsgExportsCollector(),
 		nme.newNfsgClientsCollector(),
+		nme.newNfsgSnapshotCollector(),
+		nme.newNfsgStatsStatusCollector(),
+		nme.newNfsgRestartsCollector(),
+		nme.newNfsgExportLifecycleCollector(),
+		nme.newNfsgClientLifecycleCollector(),
 	}
+	if nme.cle.enabled() {
+		cols = append(cols, nme.newNfsgClientInfoCollector())
+	}
+	if nme.vre.enabled() {
+		cols = append(cols, nme.newNfsgExportInfoCollector())
+	}
+	if nme.opts.ExportClients {
+		cols = append(cols, nme.newNfsgExportClientsCollector())
+	}
+	if nme.sks.enabled() {
+		cols = append(cols, nme.newNfsgSinksCollector())
+	}
+	if nme.opts.AdminEndpoints {
+		cols = append(cols, nme.newNfsgAdminCollector())
+	}
+	if nme.self != nil {
+		cols = append(cols, nme.newNfsgSelfInfoCollector(nme.self))
+	}
+	reg := nme.registerer()
 	for _, c := range cols {
-		if err := nme.reg.Register(c); err != nil {
+		if err := reg.Register(c); err != nil {
 			nme.log.Error(err, "failed to register collector")
 			return err
 		}
+		if k, ok := c.(interface{ kind() string }); ok {
+			nme.collectors = append(nme.collectors, k.kind())
+		}
 	}
 	return nil
 }
@@ -34,8 +63,13 @@ func collectorName(subsystem, name string) string {
 // nfsgCollector is common base type for all collectors
 type nfsgCollector struct {
 	// nolint:structcheck
-	nme *nfsgMetricsExporter
-	dsc []*prometheus.Desc
+	nme  *nfsgMetricsExporter
+	dsc  []*prometheus.Desc
+	name string
+}
+
+func (col *nfsgCollector) kind() string {
+	return col.name
 }
 
 func (col *nfsgCollector) Describe(ch chan<- *prometheus.Desc) {
@@ -68,6 +102,7 @@ func (col *nfsgVersionsCollector) Collect(ch chan<- prometheus.Metric) {
 func (nme *nfsgMetricsExporter) newNfsgVersionsCollector() prometheus.Collector {
 	col := &nfsgVersionsCollector{}
 	col.nme = nme
+	col.name = "versions"
 	col.clnt, _ = newKClient()
 	col.dsc = []*prometheus.Desc{
 		prometheus.NewDesc(
@@ -88,50 +123,43 @@ type nfsgExportsCollector struct {
 }
 
 func (col *nfsgExportsCollector) Collect(ch chan<- prometheus.Metric) {
-	reader := NewExportsDbusReader()
-	if err := reader.Setup(); err != nil {
-		col.nme.log.Error(err, "Collect exports stats")
-		return
-	}
-	defer reader.Close()
-
-	_, exports, err := reader.GetExports()
-	if err != nil {
-		col.nme.log.Error(err, "GetExports")
+	snap := col.nme.snr.snapshot()
+	if snap.exportsErr != nil {
 		return
 	}
 	ch <- prometheus.MustNewConstMetric(
 		col.dsc[0],
 		prometheus.GaugeValue,
-		float64(len(exports)))
+		float64(len(snap.exports)))
 
-	for _, export := range exports {
+	for _, ent := range snap.exports {
+		export := ent.export
 		exportID := uint16(export.ExportID)
-		stats, ok, err := reader.GetTotalOPS(exportID)
-		if err != nil || !ok {
+		stats := ent.stats
+		if stats == nil {
 			continue
 		}
 		ch <- prometheus.MustNewConstMetric(
 			col.dsc[1],
-			prometheus.GaugeValue,
+			prometheus.CounterValue,
 			float64(stats.OPS.NFSv3),
 			strconv.Itoa(int(exportID)),
 			export.Path)
 		ch <- prometheus.MustNewConstMetric(
 			col.dsc[2],
-			prometheus.GaugeValue,
+			prometheus.CounterValue,
 			float64(stats.OPS.NFSv40),
 			strconv.Itoa(int(exportID)),
 			export.Path)
 		ch <- prometheus.MustNewConstMetric(
 			col.dsc[3],
-			prometheus.GaugeValue,
+			prometheus.CounterValue,
 			float64(stats.OPS.NFSv41),
 			strconv.Itoa(int(exportID)),
 			export.Path)
 		ch <- prometheus.MustNewConstMetric(
 			col.dsc[4],
-			prometheus.GaugeValue,
+			prometheus.CounterValue,
 			float64(stats.OPS.NFSv42),
 			strconv.Itoa(int(exportID)),
 			export.Path)
@@ -141,6 +169,7 @@ func (col *nfsgExportsCollector) Collect(ch chan<- prometheus.Metric) {
 func (nme *nfsgMetricsExporter) newNfsgExportsCollector() prometheus.Collector {
 	col := &nfsgExportsCollector{}
 	col.nme = nme
+	col.name = "exports"
 	col.dsc = []*prometheus.Desc{
 		prometheus.NewDesc(
 			collectorName("export", "count"),
@@ -172,178 +201,168 @@ type nfsgClientsCollector struct {
 }
 
 func (col *nfsgClientsCollector) Collect(ch chan<- prometheus.Metric) {
-	reader := NewClientsDbusReader()
-	if err := reader.Setup(); err != nil {
-		col.nme.log.Error(err, "Collect clients stats")
-		return
-	}
-	defer reader.Close()
-
-	_, clients, err := reader.GetClients()
-	if err != nil {
-		col.nme.log.Error(err, "GetClients")
+	snap := col.nme.snr.snapshot()
+	if snap.clientsErr != nil {
 		return
 	}
 	ch <- prometheus.MustNewConstMetric(
 		col.dsc[0],
 		prometheus.GaugeValue,
-		float64(len(clients)))
+		float64(len(snap.clients)))
 
-	for _, client := range clients {
+	for _, ent := range col.nme.clf.apply(snap) {
+		client := ent.client
 		ipaddr := client.Client
-		ios, ok, err := reader.GetClientIOs(ipaddr)
-		if err != nil || !ok {
-			continue
-		}
+		ios := ent.ios
 		if client.NFSv3 {
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[1], prometheus.GaugeValue,
+				col.dsc[1], prometheus.CounterValue,
 				float64(ios.NFSv3.Read.Total), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[2], prometheus.GaugeValue,
+				col.dsc[2], prometheus.CounterValue,
 				float64(ios.NFSv3.Read.Errors), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[3], prometheus.GaugeValue,
+				col.dsc[3], prometheus.CounterValue,
 				float64(ios.NFSv3.Read.Transferred), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[4], prometheus.GaugeValue,
+				col.dsc[4], prometheus.CounterValue,
 				float64(ios.NFSv3.Write.Total), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[5], prometheus.GaugeValue,
+				col.dsc[5], prometheus.CounterValue,
 				float64(ios.NFSv3.Write.Errors), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[6], prometheus.GaugeValue,
+				col.dsc[6], prometheus.CounterValue,
 				float64(ios.NFSv3.Write.Transferred), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[7], prometheus.GaugeValue,
+				col.dsc[7], prometheus.CounterValue,
 				float64(ios.NFSv3.Other.Total), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[8], prometheus.GaugeValue,
+				col.dsc[8], prometheus.CounterValue,
 				float64(ios.NFSv3.Other.Errors), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[9], prometheus.GaugeValue,
+				col.dsc[9], prometheus.CounterValue,
 				float64(ios.NFSv3.Other.Transferred), ipaddr)
 		}
 
 		if client.NFSv40 {
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[10], prometheus.GaugeValue,
+				col.dsc[10], prometheus.CounterValue,
 				float64(ios.NFSv40.Read.Total), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[11], prometheus.GaugeValue,
+				col.dsc[11], prometheus.CounterValue,
 				float64(ios.NFSv40.Read.Errors), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[12], prometheus.GaugeValue,
+				col.dsc[12], prometheus.CounterValue,
 				float64(ios.NFSv40.Read.Transferred), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[13], prometheus.GaugeValue,
+				col.dsc[13], prometheus.CounterValue,
 				float64(ios.NFSv40.Write.Total), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[14], prometheus.GaugeValue,
+				col.dsc[14], prometheus.CounterValue,
 				float64(ios.NFSv40.Write.Errors), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[15], prometheus.GaugeValue,
+				col.dsc[15], prometheus.CounterValue,
 				float64(ios.NFSv40.Write.Transferred), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[16], prometheus.GaugeValue,
+				col.dsc[16], prometheus.CounterValue,
 				float64(ios.NFSv40.Other.Total), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[17], prometheus.GaugeValue,
+				col.dsc[17], prometheus.CounterValue,
 				float64(ios.NFSv40.Other.Errors), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[18], prometheus.GaugeValue,
+				col.dsc[18], prometheus.CounterValue,
 				float64(ios.NFSv40.Other.Transferred), ipaddr)
 		}
 
 		if client.NFSv41 {
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[19], prometheus.GaugeValue,
+				col.dsc[19], prometheus.CounterValue,
 				float64(ios.NFSv41.Read.Total), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[20], prometheus.GaugeValue,
+				col.dsc[20], prometheus.CounterValue,
 				float64(ios.NFSv41.Read.Errors), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[21], prometheus.GaugeValue,
+				col.dsc[21], prometheus.CounterValue,
 				float64(ios.NFSv41.Read.Transferred), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[22], prometheus.GaugeValue,
+				col.dsc[22], prometheus.CounterValue,
 				float64(ios.NFSv41.Write.Total), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[23], prometheus.GaugeValue,
+				col.dsc[23], prometheus.CounterValue,
 				float64(ios.NFSv41.Write.Errors), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[24], prometheus.GaugeValue,
+				col.dsc[24], prometheus.CounterValue,
 				float64(ios.NFSv41.Write.Transferred), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[25], prometheus.GaugeValue,
+				col.dsc[25], prometheus.CounterValue,
 				float64(ios.NFSv41.Other.Total), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[26], prometheus.GaugeValue,
+				col.dsc[26], prometheus.CounterValue,
 				float64(ios.NFSv41.Other.Errors), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[27], prometheus.GaugeValue,
+				col.dsc[27], prometheus.CounterValue,
 				float64(ios.NFSv41.Other.Transferred), ipaddr)
 		}
 
 		if client.NFSv42 {
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[28], prometheus.GaugeValue,
+				col.dsc[28], prometheus.CounterValue,
 				float64(ios.NFSv42.Read.Total), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[29], prometheus.GaugeValue,
+				col.dsc[29], prometheus.CounterValue,
 				float64(ios.NFSv42.Read.Errors), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[30], prometheus.GaugeValue,
+				col.dsc[30], prometheus.CounterValue,
 				float64(ios.NFSv42.Read.Transferred), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[31], prometheus.GaugeValue,
+				col.dsc[31], prometheus.CounterValue,
 				float64(ios.NFSv42.Write.Total), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[32], prometheus.GaugeValue,
+				col.dsc[32], prometheus.CounterValue,
 				float64(ios.NFSv42.Write.Errors), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[33], prometheus.GaugeValue,
+				col.dsc[33], prometheus.CounterValue,
 				float64(ios.NFSv42.Write.Transferred), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[34], prometheus.GaugeValue,
+				col.dsc[34], prometheus.CounterValue,
 				float64(ios.NFSv42.Other.Total), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[35], prometheus.GaugeValue,
+				col.dsc[35], prometheus.CounterValue,
 				float64(ios.NFSv42.Other.Errors), ipaddr)
 
 			ch <- prometheus.MustNewConstMetric(
-				col.dsc[36], prometheus.GaugeValue,
+				col.dsc[36], prometheus.CounterValue,
 				float64(ios.NFSv42.Other.Transferred), ipaddr)
 		}
 	}
@@ -352,6 +371,7 @@ func (col *nfsgClientsCollector) Collect(ch chan<- prometheus.Metric) {
 func (nme *nfsgMetricsExporter) newNfsgClientsCollector() prometheus.Collector {
 	col := &nfsgClientsCollector{}
 	col.nme = nme
+	col.name = "clients"
 	col.dsc = []*prometheus.Desc{
 		prometheus.NewDesc(
 			collectorName("client", "count"),
@@ -475,3 +495,138 @@ func (nme *nfsgMetricsExporter) newNfsgClientsCollector() prometheus.Collector {
 	}
 	return col
 }
+
+// nfsgSnapshotCollector exports the staleness and health of stats snapshots
+type nfsgSnapshotCollector struct {
+	nfsgCollector
+}
+
+func (col *nfsgSnapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	st := col.nme.snr.stats()
+	age := float64(0)
+	if !st.lastTime.IsZero() {
+		age = time.Since(st.lastTime).Seconds()
+	}
+	lastSuccess := float64(0)
+	if !st.lastSuccess.IsZero() {
+		lastSuccess = float64(st.lastSuccess.UnixNano()) / 1e9
+	}
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[0], prometheus.GaugeValue, age)
+
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[1], prometheus.GaugeValue, lastSuccess)
+
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[2], prometheus.GaugeValue, st.lastDuration.Seconds())
+
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[3], prometheus.CounterValue, float64(st.refreshes))
+
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[4], prometheus.CounterValue, float64(st.failures))
+}
+
+func (nme *nfsgMetricsExporter) newNfsgSnapshotCollector() prometheus.Collector {
+	col := &nfsgSnapshotCollector{}
+	col.nme = nme
+	col.name = "snapshot"
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("snapshot", "age_seconds"),
+			"Age of the current stats snapshot", []string{}, nil),
+		prometheus.NewDesc(
+			collectorName("snapshot", "last_success_timestamp_seconds"),
+			"Time of the last successful stats snapshot", []string{}, nil),
+		prometheus.NewDesc(
+			collectorName("snapshot", "refresh_duration_seconds"),
+			"Duration of the last stats snapshot refresh", []string{}, nil),
+		prometheus.NewDesc(
+			collectorName("snapshot", "refreshes_total"),
+			"Total number of stats snapshot refreshes", []string{}, nil),
+		prometheus.NewDesc(
+			collectorName("snapshot", "refresh_failures_total"),
+			"Total number of failed stats snapshot refreshes", []string{}, nil),
+	}
+	return col
+}
+
+// nfsgClientInfoCollector exports the resolved identity of NFS-Ganesha
+// clients, to be joined with per-client metrics on ipaddr
+type nfsgClientInfoCollector struct {
+	nfsgCollector
+}
+
+func (col *nfsgClientInfoCollector) Collect(ch chan<- prometheus.Metric) {
+	snap := col.nme.snr.snapshot()
+	if snap.clientsErr != nil {
+		return
+	}
+	col.nme.cle.prune(snap)
+	for _, ent := range col.nme.clf.apply(snap) {
+		ipaddr := ent.client.Client
+		if net.ParseIP(ipaddr) == nil {
+			continue
+		}
+		id := col.nme.cle.identify(ipaddr)
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[0], prometheus.GaugeValue, 1,
+			ipaddr, id.hostname, id.pod, id.namespace, id.node)
+	}
+}
+
+func (nme *nfsgMetricsExporter) newNfsgClientInfoCollector() prometheus.Collector {
+	col := &nfsgClientInfoCollector{}
+	col.nme = nme
+	col.name = "client_info"
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("client", "info"),
+			"Identity of NFS client",
+			[]string{"ipaddr", "hostname", "pod", "namespace", "node"}, nil),
+	}
+	return col
+}
+
+// nfsgExportInfoCollector exports the mapping of NFS-Ganesha exports to
+// Kubernetes persistent volumes and claims
+type nfsgExportInfoCollector struct {
+	nfsgCollector
+}
+
+func (col *nfsgExportInfoCollector) Collect(ch chan<- prometheus.Metric) {
+	snap := col.nme.snr.snapshot()
+	if snap.exportsErr != nil {
+		return
+	}
+	refs := col.nme.vre.resolve(snap.exports)
+	for _, ent := range snap.exports {
+		exportID := strconv.Itoa(int(ent.export.ExportID))
+		for _, ref := range refs[ent.export.ExportID] {
+			ch <- prometheus.MustNewConstMetric(
+				col.dsc[0], prometheus.GaugeValue, 1,
+				exportID, ent.export.Path,
+				ref.pv, ref.pvc, ref.namespace, ref.storageClass)
+		}
+	}
+}
+
+func (nme *nfsgMetricsExporter) newNfsgExportInfoCollector() prometheus.Collector {
+	col := &nfsgExportInfoCollector{}
+	col.nme = nme
+	col.name = "export_info"
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("export", "info"),
+			"Kubernetes persistent volume of NFS export",
+			[]string{
+				"exportid",
+				"path",
+				"pv",
+				"pvc",
+				"namespace",
+				"storageclass",
+			}, nil),
+	}
+	return col
+}
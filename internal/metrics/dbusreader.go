@@ -3,12 +3,14 @@
 package metrics
 
 import (
+	"context"
 	"errors"
 	"os"
 	"reflect"
 	"strconv"
 
 	dbus "github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
 	"golang.org/x/sys/unix"
 )
 
@@ -22,6 +24,11 @@ const (
 	nfsGaneshaClientInterface       = "/org/ganesha/nfsd/ClientMgr"
 )
 
+// DbusTraceFunc is called upon each DBus method call with its arguments,
+// raw reply body and error
+type DbusTraceFunc func(method string, args []interface{},
+	body []interface{}, err error)
+
 // DbusReader
 type DbusReader struct {
 	dbusServicePrefix string
@@ -30,6 +37,18 @@ type DbusReader struct {
 	dbusInterfacePath string
 	dbusConn          *dbus.Conn
 	dbusObject        dbus.BusObject
+	traceFn           DbusTraceFunc
+}
+
+// SetTrace assigns a hook function which is called upon each DBus call
+func (dr *DbusReader) SetTrace(fn DbusTraceFunc) {
+	dr.traceFn = fn
+}
+
+func (dr *DbusReader) trace(method string, args []interface{}, call *dbus.Call) {
+	if dr.traceFn != nil {
+		dr.traceFn(method, args, call.Body, call.Err)
+	}
 }
 
 func (dr *DbusReader) Setup() error {
@@ -66,8 +85,56 @@ func (dr *DbusReader) Close() {
 	}
 }
 
+// NameOwner returns the unique bus name of the current owner of
+// NFS-Ganesha's DBus service
+func (dr *DbusReader) NameOwner() (string, error) {
+	owner := ""
+	err := dr.dbusConn.BusObject().Call(
+		"org.freedesktop.DBus.GetNameOwner", 0, dr.dbusServicePrefix).Store(&owner)
+	return owner, err
+}
+
+// NameOwnerContext is NameOwner bounded by the given context
+func (dr *DbusReader) NameOwnerContext(ctx context.Context) (string, error) {
+	owner := ""
+	err := dr.dbusConn.BusObject().CallWithContext(ctx,
+		"org.freedesktop.DBus.GetNameOwner", 0, dr.dbusServicePrefix).Store(&owner)
+	return owner, err
+}
+
+// OwnerProcessID returns the process ID of the given bus name's owner
+func (dr *DbusReader) OwnerProcessID(owner string) (uint32, error) {
+	pid := uint32(0)
+	err := dr.dbusConn.BusObject().Call(
+		"org.freedesktop.DBus.GetConnectionUnixProcessID", 0, owner).Store(&pid)
+	return pid, err
+}
+
+// MissingMethods returns those of the given methods, in "interface.Method"
+// form, which are not provided by the reader's DBus object
+func (dr *DbusReader) MissingMethods(methods ...string) ([]string, error) {
+	node, err := introspect.Call(dr.dbusObject)
+	if err != nil {
+		return nil, err
+	}
+	provided := map[string]bool{}
+	for _, iface := range node.Interfaces {
+		for _, m := range iface.Methods {
+			provided[iface.Name+"."+m.Name] = true
+		}
+	}
+	missing := []string{}
+	for _, method := range methods {
+		if !provided[method] {
+			missing = append(missing, method)
+		}
+	}
+	return missing, nil
+}
+
 func (dr *DbusReader) makeDbusCall(method string) (*dbus.Call, error) {
 	call := dr.dbusObject.Call(method, 0)
+	dr.trace(method, nil, call)
 	err := call.Err
 	if err != nil {
 		return nil, err
@@ -78,6 +145,7 @@ func (dr *DbusReader) makeDbusCall(method string) (*dbus.Call, error) {
 func (dr *DbusReader) makeDbusCallWith(
 	method string, args ...interface{}) (*dbus.Call, bool, error) {
 	call := dr.dbusObject.Call(method, 0, args...)
+	dr.trace(method, args, call)
 	err := call.Err
 	if err != nil {
 		return nil, false, err
@@ -155,6 +223,7 @@ func (exdr *ExportsDbusReader) GetTotalOPS(
 	}
 	out.Status, _ = call.Body[0].(bool)
 	out.Error, _ = call.Body[1].(string)
+	out.Time = parseTimespec(call.Body[2])
 	out.OPS = parseOPs(call.Body[3])
 	return &out, true, nil
 }
@@ -177,10 +246,133 @@ func (exdr *ExportsDbusReader) GetGlobalOPS(
 	}
 	out.Status, _ = call.Body[0].(bool)
 	out.Error, _ = call.Body[1].(string)
+	out.Time = parseTimespec(call.Body[2])
 	out.OPS = parseOPs(call.Body[3])
 	return &out, true, nil
 }
 
+// GetStatsStatus returns the enabled status of each type of NFS-Ganesha
+// stats. The reply holds a struct per type, in the order of StatsTypes, of
+// its enabled flag and, only when enabled, the time since which it is
+func (exdr *ExportsDbusReader) GetStatsStatus() (*StatsStatus, bool, error) {
+	method := exdr.statsMethod("StatusStats")
+	call, status, err := exdr.makeDbusCallWith(method)
+	if err != nil {
+		return nil, status, err
+	}
+	out := StatsStatus{}
+	if !status || len(call.Body) < 2 {
+		err = call.Store(&out.Status, &out.Error)
+		return &out, status, err
+	}
+	out.Status, _ = call.Body[0].(bool)
+	out.Error, _ = call.Body[1].(string)
+	for i, v := range call.Body[2:] {
+		if i >= len(StatsTypes) || !isSlice(v) {
+			continue
+		}
+		dat := reflect.ValueOf(v)
+		if dat.Len() < 1 {
+			continue
+		}
+		st := StatsState{Type: StatsTypes[i]}
+		st.Enabled, _ = asBool(dat.Index(0))
+		if st.Enabled && dat.Len() > 1 {
+			st.Since = parseTimespec(dat.Index(1).Interface())
+		}
+		out.Stats = append(out.Stats, st)
+	}
+	return &out, true, nil
+}
+
+// EnableStats enables the collection of NFS-Ganesha stats of the given type,
+// or of all types
+func (exdr *ExportsDbusReader) EnableStats(statsType string) (*ReplyHeader, bool, error) {
+	method := exdr.statsMethod("EnableStats")
+	call, status, err := exdr.makeDbusCallWith(method, statsType)
+	if err != nil {
+		return nil, status, err
+	}
+	out := ReplyHeader{}
+	out.Status, _ = call.Body[0].(bool)
+	out.Error, _ = call.Body[1].(string)
+	if len(call.Body) > 2 {
+		out.Time = parseTimespec(call.Body[2])
+	}
+	return &out, status, nil
+}
+
+// ResetStats zeroes all NFS-Ganesha stats counters
+func (exdr *ExportsDbusReader) ResetStats() (*ReplyHeader, bool, error) {
+	method := exdr.statsMethod("ResetStats")
+	call, status, err := exdr.makeDbusCallWith(method)
+	if err != nil {
+		return nil, status, err
+	}
+	out := ReplyHeader{}
+	out.Status, _ = call.Body[0].(bool)
+	out.Error, _ = call.Body[1].(string)
+	if len(call.Body) > 2 {
+		out.Time = parseTimespec(call.Body[2])
+	}
+	return &out, status, nil
+}
+
+// DisplayExport returns the details of a single export, including the
+// clients of its access list
+func (exdr *ExportsDbusReader) DisplayExport(exportID uint16) (*ExportDetails, error) {
+	method := exdr.mgrMethod("DisplayExport")
+	call := exdr.dbusObject.Call(method, 0, exportID)
+	exdr.trace(method, []interface{}{exportID}, call)
+	if call.Err != nil {
+		return nil, call.Err
+	}
+	if len(call.Body) < 5 || !isSlice(call.Body[4]) {
+		return nil, errors.New("protocol error")
+	}
+	out := ExportDetails{}
+	out.ExportID, _ = call.Body[0].(uint16)
+	out.FullPath, _ = call.Body[1].(string)
+	out.PseudoPath, _ = call.Body[2].(string)
+	out.Tag, _ = call.Body[3].(string)
+	out.Clients = parseExportClients(call.Body[4])
+	return &out, nil
+}
+
+// parseExportClients extracts the client of each export client struct,
+// which is its first member
+func parseExportClients(v interface{}) []string {
+	dat := reflect.ValueOf(v)
+	clients := make([]string, 0, dat.Len())
+	for i := 0; i < dat.Len(); i++ {
+		ent := reflect.ValueOf(dat.Index(i).Interface())
+		if ent.Kind() != reflect.Slice || ent.Len() < 1 {
+			continue
+		}
+		if client, ok := asString(ent.Index(0)); ok {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// parseTimespec converts DBus timestamp struct of (seconds, nanoseconds)
+func parseTimespec(v interface{}) unix.Timespec {
+	ts := unix.Timespec{}
+	if !isSlice(v) {
+		return ts
+	}
+	dat := reflect.ValueOf(v)
+	if dat.Len() < 2 {
+		return ts
+	}
+	sec, _ := asUint64(dat.Index(0))
+	nsec, _ := asUint64(dat.Index(1))
+	ts.Sec = int64(sec)
+	ts.Nsec = int64(nsec)
+	return ts
+}
+
 func parseOPs(v interface{}) OperationCount {
 	ops := OperationCount{}
 	dat := reflect.ValueOf(v)
@@ -299,6 +491,9 @@ func (cldr *ClientsDbusReader) GetClientIOs(
 		_ = call.Store(&out.Status, &out.Error)
 		return &out, status, errors.New("protocol error")
 	}
+	out.Status, _ = call.Body[0].(bool)
+	out.Error, _ = call.Body[1].(string)
+	out.Time = parseTimespec(call.Body[2])
 	out.ClientIOStats = parseClientIOs(call.Body[3:])
 	return &out, true, nil
 }
@@ -452,6 +647,13 @@ func asBool(v reflect.Value) (bool, bool) {
 	return b, true
 }
 
+// isUnknownMethod returns true if a DBus call failed since the remote object
+// lacks the method, as with older NFS-Ganesha versions
+func isUnknownMethod(err error) bool {
+	derr := dbus.Error{}
+	return errors.As(err, &derr) && derr.Name == dbus.ErrMsgUnknownMethod.Name
+}
+
 func isSlice(v interface{}) bool {
 	return reflect.TypeOf(v).Kind() == reflect.Slice
 }
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultDebugDbusPath is the HTTP path of last raw DBus replies page
+	DefaultDebugDbusPath = "/debug/dbus"
+	// DefaultDebugPprofPath is the HTTP path prefix of pprof handlers
+	DefaultDebugPprofPath = "/debug/pprof/"
+)
+
+// nfsgDbusReply is a recorded raw DBus reply
+type nfsgDbusReply struct {
+	Method string
+	Time   time.Time
+	Args   string
+	Body   string
+	Err    string
+}
+
+// nfsgDbusTracer records the last raw DBus reply of each method
+type nfsgDbusTracer struct {
+	mu      sync.Mutex
+	replies map[string]*nfsgDbusReply
+}
+
+func newNfsgDbusTracer() *nfsgDbusTracer {
+	return &nfsgDbusTracer{replies: map[string]*nfsgDbusReply{}}
+}
+
+func (dt *nfsgDbusTracer) record(method string, args []interface{},
+	body []interface{}, err error) {
+	rep := &nfsgDbusReply{
+		Method: method,
+		Time:   time.Now(),
+		Args:   fmt.Sprintf("%v", args),
+		Body:   fmt.Sprintf("%#v", body),
+	}
+	if err != nil {
+		rep.Err = err.Error()
+	}
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	dt.replies[method] = rep
+}
+
+func (dt *nfsgDbusTracer) list() []nfsgDbusReply {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	ret := make([]nfsgDbusReply, 0, len(dt.replies))
+	for _, rep := range dt.replies {
+		ret = append(ret, *rep)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Method < ret[j].Method
+	})
+	return ret
+}
+
+var debugDbusTemplate = template.Must(template.New("dbus").Parse(`<!DOCTYPE html>
+<html>
+<head><title>NFS-Ganesha DBus Replies</title></head>
+<body>
+<h1>Last DBus replies</h1>
+<table border="1" cellpadding="4">
+<tr><th>Method</th><th>Time</th><th>Args</th><th>Error</th><th>Body</th></tr>
+{{range .}}<tr>
+<td>{{.Method}}</td>
+<td>{{.Time.Format "2006-01-02T15:04:05.000Z07:00"}}</td>
+<td><code>{{.Args}}</code></td>
+<td>{{.Err}}</td>
+<td><pre>{{.Body}}</pre></td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (nme *nfsgMetricsExporter) handleDebug() {
+	if !nme.opts.DebugEndpoints {
+		return
+	}
+	nme.mux.HandleFunc(DefaultDebugDbusPath, nme.serveDebugDbus)
+	nme.mux.HandleFunc(DefaultDebugPprofPath, pprof.Index)
+	nme.mux.HandleFunc(DefaultDebugPprofPath+"cmdline", pprof.Cmdline)
+	nme.mux.HandleFunc(DefaultDebugPprofPath+"profile", pprof.Profile)
+	nme.mux.HandleFunc(DefaultDebugPprofPath+"symbol", pprof.Symbol)
+	nme.mux.HandleFunc(DefaultDebugPprofPath+"trace", pprof.Trace)
+}
+
+func (nme *nfsgMetricsExporter) serveDebugDbus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := debugDbusTemplate.Execute(w, nme.dbt.list()); err != nil {
+		nme.log.Error(err, "failed to render DBus replies page")
+	}
+}
@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-logr/logr"
+)
+
+// DumpOptions selects the content and format of one-shot stats dump
+type DumpOptions struct {
+	// Exports dumps exports and their stats
+	Exports bool
+	// Clients dumps clients and their stats
+	Clients bool
+	// JSON dumps in JSON format instead of tables
+	JSON bool
+}
+
+// APIDump is the JSON output of one-shot stats dump
+type APIDump struct {
+	Exports *APIExports `json:",omitempty"`
+	Clients *APIClients `json:",omitempty"`
+}
+
+// DumpNfsgStats fetches NFS-Ganesha stats once and prints them to w. It
+// fails if any of the selected stats could not be fetched.
+func DumpNfsgStats(w io.Writer, log logr.Logger, opts *Options, dopts DumpOptions) error {
+	if !dopts.Exports && !dopts.Clients {
+		dopts.Exports = true
+		dopts.Clients = true
+	}
+	nme := newNfsgMetricsExporter(log, opts)
+	defer nme.close()
+
+	snap := nme.snr.refresh()
+	if dopts.Exports && snap.exportsErr != nil {
+		return snap.exportsErr
+	}
+	if dopts.Clients && snap.clientsErr != nil {
+		return snap.clientsErr
+	}
+	dump := &APIDump{}
+	if dopts.Exports {
+		dump.Exports = makeAPIExports(snap)
+	}
+	if dopts.Clients {
+		dump.Clients = makeAPIClients(snap, "", nil)
+	}
+	if dopts.JSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(dump)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if dump.Exports != nil {
+		printExports(tw, dump.Exports)
+	}
+	if dump.Exports != nil && dump.Clients != nil {
+		fmt.Fprintln(tw)
+	}
+	if dump.Clients != nil {
+		printClients(tw, dump.Clients)
+	}
+	return tw.Flush()
+}
+
+func printExports(w io.Writer, exports *APIExports) {
+	fmt.Fprintln(w, "EXPORT\tPATH\tPROTOCOLS\tNFSv3\tNFSv40\tNFSv41\tNFSv42\tNLMv4\tMNT")
+	for _, ex := range exports.Exports {
+		ops := OperationCount{}
+		if ex.Stats != nil {
+			ops = ex.Stats.OPS
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			ex.ExportID, ex.Path, exportProtocols(&ex.Export),
+			ops.NFSv3, ops.NFSv40, ops.NFSv41, ops.NFSv42, ops.NLMv4,
+			ops.MNTv1+ops.MNTv3)
+	}
+}
+
+func printClients(w io.Writer, clients *APIClients) {
+	fmt.Fprintln(w, "CLIENT\tPROTOCOLS\tREAD_OPS\tREAD_BYTES\tWRITE_OPS\tWRITE_BYTES\tERRORS")
+	for _, cl := range clients.Clients {
+		sum := IOStats{}
+		if cl.IOs != nil {
+			sum = sumIOStats(&cl.IOs.ClientIOStats)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\n",
+			cl.Client.Client, clientProtocols(&cl.Client),
+			sum.Read.Total, sum.Read.Transferred,
+			sum.Write.Total, sum.Write.Transferred, ioErrors(&sum))
+	}
+}
+
+// sumIOStats sums the IO stats of a client over all NFS versions
+func sumIOStats(ios *ClientIOStats) IOStats {
+	sum := IOStats{}
+	for _, st := range []*IOStats{&ios.NFSv3, &ios.NFSv40, &ios.NFSv41, &ios.NFSv42} {
+		addIOStats(&sum, st)
+	}
+	return sum
+}
+
+func ioErrors(st *IOStats) uint64 {
+	return st.Read.Errors + st.Write.Errors + st.Other.Errors + st.Layout.Errors
+}
+
+func exportProtocols(ex *Export) string {
+	return joinProtocols(ex.NFSv3, ex.NFSv40, ex.NFSv41, ex.NFSv42, ex.MNTv3,
+		ex.NLMv4, ex.RQUOTA, ex.Plan9)
+}
+
+func clientProtocols(cl *Client) string {
+	return joinProtocols(cl.NFSv3, cl.NFSv40, cl.NFSv41, cl.NFSv42, cl.MNTv3,
+		cl.NLMv4, cl.RQUOTA, cl.Plan9)
+}
+
+func joinProtocols(flags ...bool) string {
+	names := []string{"v3", "v4.0", "v4.1", "v4.2", "mnt", "nlm", "rquota", "9p"}
+	protos := []string{}
+	for i, flag := range flags {
+		if flag {
+			protos = append(protos, names[i])
+		}
+	}
+	if len(protos) == 0 {
+		return "-"
+	}
+	return strings.Join(protos, ",")
+}
@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	podIPIndex = "podIP"
+	// dnsMaxLookups is the maximal number of concurrent reverse-DNS lookups
+	dnsMaxLookups = 8
+)
+
+// nfsgClientIdentity holds the resolved identity of a client IP address
+type nfsgClientIdentity struct {
+	hostname  string
+	pod       string
+	namespace string
+	node      string
+}
+
+// nfsgDNSEntry is a cached result of reverse-DNS lookup
+type nfsgDNSEntry struct {
+	hostname string
+	expires  time.Time
+	pending  bool
+}
+
+// nfsgClientEnricher maps clients IP addresses to Kubernetes pods and
+// reverse-DNS hostnames. Lookups never block the caller: pods are resolved
+// from a local informer cache and DNS lookups are done in the background
+type nfsgClientEnricher struct {
+	nme      *nfsgMetricsExporter
+	pods     cache.Indexer
+	mu       sync.Mutex
+	dnsCache map[string]*nfsgDNSEntry
+	dnsSlots chan struct{}
+	ctx      context.Context
+}
+
+func newNfsgClientEnricher(nme *nfsgMetricsExporter) *nfsgClientEnricher {
+	return &nfsgClientEnricher{
+		nme:      nme,
+		dnsCache: map[string]*nfsgDNSEntry{},
+		dnsSlots: make(chan struct{}, dnsMaxLookups),
+		ctx:      context.Background(),
+	}
+}
+
+func (ce *nfsgClientEnricher) enabled() bool {
+	return ce.nme.opts.EnrichClientPods || ce.nme.opts.EnrichClientDNS
+}
+
+// start launches the pods informer, if pods enrichment is enabled
+func (ce *nfsgClientEnricher) start(ctx context.Context) {
+	ce.ctx = ctx
+	if !ce.nme.opts.EnrichClientPods {
+		return
+	}
+	clnt, err := ce.nme.getKClient()
+	if err != nil {
+		ce.nme.log.Error(err, "failed to create kubernetes client")
+		return
+	}
+	factory := informers.NewSharedInformerFactory(clnt.ClientSet, 0)
+	informer := factory.Core().V1().Pods().Informer()
+	err = informer.AddIndexers(cache.Indexers{podIPIndex: podIPIndexFunc})
+	if err != nil {
+		ce.nme.log.Error(err, "failed to add pods indexer")
+		return
+	}
+	ce.nme.log.Info("start pods informer")
+	factory.Start(ctx.Done())
+	ce.pods = informer.GetIndexer()
+}
+
+func podIPIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.HostNetwork {
+		return []string{}, nil
+	}
+	ips := []string{}
+	for _, podIP := range pod.Status.PodIPs {
+		ips = append(ips, podIP.IP)
+	}
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		ips = append(ips, pod.Status.PodIP)
+	}
+	return ips, nil
+}
+
+// identify resolves the identity of a client by its IP address
+func (ce *nfsgClientEnricher) identify(ipaddr string) nfsgClientIdentity {
+	id := nfsgClientIdentity{}
+	ip := net.ParseIP(ipaddr)
+	if ip == nil {
+		return id
+	}
+	addr := ip.String()
+	if ce.nme.opts.EnrichClientPods {
+		ce.lookupPod(addr, &id)
+	}
+	if ce.nme.opts.EnrichClientDNS {
+		id.hostname = ce.lookupDNS(addr)
+	}
+	return id
+}
+
+func (ce *nfsgClientEnricher) lookupPod(addr string, id *nfsgClientIdentity) {
+	if ce.pods == nil {
+		return
+	}
+	objs, err := ce.pods.ByIndex(podIPIndex, addr)
+	if err != nil {
+		return
+	}
+	for _, obj := range objs {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			continue
+		}
+		id.pod = pod.Name
+		id.namespace = pod.Namespace
+		id.node = pod.Spec.NodeName
+		if pod.Status.Phase == corev1.PodRunning {
+			return
+		}
+	}
+}
+
+// lookupDNS returns the cached hostname of addr, and starts a background
+// lookup if it has expired. When all lookup slots are busy, the lookup is
+// deferred to a later call
+func (ce *nfsgClientEnricher) lookupDNS(addr string) string {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+
+	ent, ok := ce.dnsCache[addr]
+	if ok && (ent.pending || time.Now().Before(ent.expires)) {
+		return ent.hostname
+	}
+	if !ok {
+		ent = &nfsgDNSEntry{}
+		ce.dnsCache[addr] = ent
+	}
+	select {
+	case ce.dnsSlots <- struct{}{}:
+	default:
+		return ent.hostname
+	}
+	ent.pending = true
+	go ce.resolveDNS(addr, ent)
+	return ent.hostname
+}
+
+func (ce *nfsgClientEnricher) resolveDNS(addr string, ent *nfsgDNSEntry) {
+	defer func() { <-ce.dnsSlots }()
+
+	ctx, cancel := context.WithTimeout(ce.ctx, 5*time.Second)
+	defer cancel()
+
+	hostname := ""
+	names, err := net.DefaultResolver.LookupAddr(ctx, addr)
+	if err == nil && len(names) > 0 {
+		hostname = strings.TrimSuffix(names[0], ".")
+	}
+
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+	ent.hostname = hostname
+	ent.expires = time.Now().Add(ce.nme.opts.EnrichClientDNSTTL)
+	ent.pending = false
+}
+
+// prune drops cached DNS entries of clients which are no longer active
+func (ce *nfsgClientEnricher) prune(snap *nfsgSnapshot) {
+	active := make(map[string]bool, len(snap.clients))
+	for _, ent := range snap.clients {
+		if ip := net.ParseIP(ent.client.Client); ip != nil {
+			active[ip.String()] = true
+		}
+	}
+
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+	for addr, ent := range ce.dnsCache {
+		if !active[addr] && !ent.pending {
+			delete(ce.dnsCache, addr)
+		}
+	}
+}
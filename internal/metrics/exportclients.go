@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// nfsgExportClientsCollector exports the clients of each NFS-Ganesha export.
+// NFS-Ganesha does not report per-client stats of an export, so a client is
+// attributed to an export when it is connected and matches a host, network
+// or wildcard entry of the export's access list, as listed by DisplayExport.
+// Host-name and netgroup entries are not matched. The total number of
+// per-export client series is capped; the remaining ones are counted as
+// dropped
+type nfsgExportClientsCollector struct {
+	nfsgCollector
+}
+
+func (col *nfsgExportClientsCollector) Collect(ch chan<- prometheus.Metric) {
+	snap := col.nme.snr.snapshot()
+	if snap.exportsErr != nil || snap.clientsErr != nil {
+		return
+	}
+	budget := col.nme.opts.ExportClientsMax
+	dropped := 0
+	for _, ent := range snap.exports {
+		if ent.clients == nil {
+			continue
+		}
+		exportID := strconv.FormatUint(uint64(ent.export.ExportID), 10)
+		acl := newNfsgAccessList(ent.clients)
+		ipaddrs := []string{}
+		for _, cent := range snap.clients {
+			ipaddr := cent.client.Client
+			if col.nme.clf.allowed(ipaddr) && acl.matches(ipaddr) {
+				ipaddrs = append(ipaddrs, ipaddr)
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[0], prometheus.GaugeValue, float64(len(ipaddrs)), exportID)
+
+		for _, ipaddr := range ipaddrs {
+			if budget <= 0 {
+				dropped++
+				continue
+			}
+			budget--
+			ch <- prometheus.MustNewConstMetric(
+				col.dsc[1], prometheus.GaugeValue, 1, exportID, ipaddr)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[2], prometheus.GaugeValue, float64(dropped))
+}
+
+func (nme *nfsgMetricsExporter) newNfsgExportClientsCollector() prometheus.Collector {
+	col := &nfsgExportClientsCollector{}
+	col.nme = nme
+	col.name = "export_clients"
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("export", "clients"),
+			"Number of connected clients matching access list of export",
+			[]string{"exportid"}, nil),
+		prometheus.NewDesc(
+			collectorName("export", "client_info"),
+			"Connected client matching access list of export",
+			[]string{"exportid", "ipaddr"}, nil),
+		prometheus.NewDesc(
+			collectorName("export", "client_info_dropped"),
+			"Number of export client series not exported due to series limit",
+			[]string{}, nil),
+	}
+	return col
+}
+
+// nfsgAccessList matches client addresses against the host, network and
+// wildcard entries of an export's access list
+type nfsgAccessList struct {
+	any   bool
+	hosts map[string]bool
+	nets  []*net.IPNet
+}
+
+func newNfsgAccessList(entries []string) *nfsgAccessList {
+	acl := &nfsgAccessList{hosts: map[string]bool{}}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "*" {
+			acl.any = true
+		} else if ip := net.ParseIP(entry); ip != nil {
+			acl.hosts[ip.String()] = true
+		} else if _, ipnet, err := net.ParseCIDR(entry); err == nil {
+			acl.nets = append(acl.nets, ipnet)
+		}
+	}
+	return acl
+}
+
+func (acl *nfsgAccessList) matches(ipaddr string) bool {
+	if acl.any {
+		return true
+	}
+	ip := net.ParseIP(ipaddr)
+	if ip == nil {
+		return false
+	}
+	return acl.hosts[ip.String()] || containsIP(acl.nets, ip)
+}
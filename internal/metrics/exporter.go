@@ -3,13 +3,15 @@
 package metrics
 
 import (
-	"fmt"
+	"context"
+	"crypto/tls"
+	"errors"
 	"net"
 	"net/http"
 
 	"github.com/go-logr/logr"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
 var (
@@ -21,53 +23,259 @@ var (
 
 type nfsgMetricsExporter struct {
 	log  logr.Logger
+	opts *Options
 	reg  *prometheus.Registry
 	mux  *http.ServeMux
-	port int
+	snr  *nfsgSnapshotter
+	clf  *nfsgClientFilter
+	cle  *nfsgClientEnricher
+	vre  *nfsgVolumeResolver
+	kcl  *kclient
+	self *nfsgSelfInfo
+	wgd  *nfsgWebGuard
+	dbt  *nfsgDbusTracer
+	sks  *nfsgSinks
+	aud  *nfsgAdminAudit
+
+	registered bool
+	collectors []string
 }
 
-func newNfsgMetricsExporter(log logr.Logger, port int) *nfsgMetricsExporter {
-	return &nfsgMetricsExporter{
+func newNfsgMetricsExporter(log logr.Logger, opts *Options) *nfsgMetricsExporter {
+	nme := &nfsgMetricsExporter{
 		log:  log,
+		opts: opts,
 		reg:  prometheus.NewRegistry(),
 		mux:  http.NewServeMux(),
-		port: port,
+		dbt:  newNfsgDbusTracer(),
+		aud:  newNfsgAdminAudit(),
 	}
+	nme.snr = newNfsgSnapshotter(nme)
+	nme.cle = newNfsgClientEnricher(nme)
+	nme.vre = newNfsgVolumeResolver(nme)
+	return nme
+}
+
+func (nme *nfsgMetricsExporter) getKClient() (*kclient, error) {
+	if nme.kcl != nil {
+		return nme.kcl, nil
+	}
+	clnt, err := newKClient()
+	if err != nil {
+		return nil, err
+	}
+	nme.kcl = clnt
+	return clnt, nil
 }
 
 func (nme *nfsgMetricsExporter) init() error {
+	clf, err := newNfsgClientFilter(nme.opts)
+	if err != nil {
+		nme.log.Error(err, "illegal clients filter options")
+		return err
+	}
+	nme.clf = clf
+
+	if err := checkStatsTypes(nme.opts.EnableStats); err != nil {
+		nme.log.Error(err, "illegal enable-stats option")
+		return err
+	}
+
+	wgd, err := newNfsgWebGuard(nme)
+	if err != nil {
+		nme.log.Error(err, "illegal web config", "path", nme.opts.WebConfigFile)
+		return err
+	}
+	nme.wgd = wgd
+
+	if err := nme.checkAdmin(); err != nil {
+		nme.log.Error(err, "illegal admin options")
+		return err
+	}
+
+	if nme.opts.SelfPodInfo {
+		self, err := nme.resolveSelfInfo()
+		if err != nil {
+			nme.log.Error(err, "failed to resolve self pod info")
+		} else {
+			nme.log.Info("self pod info", "pod", self.pod,
+				"namespace", self.namespace, "node", self.node,
+				"workload", self.workload)
+			nme.self = self
+		}
+	}
+
+	if nme.opts.ExportEvents {
+		if err := nme.snr.ext.startEvents(); err != nil {
+			nme.log.Error(err, "failed to start export events")
+		}
+	}
+
+	sks, err := newNfsgSinks(nme)
+	if err != nil {
+		nme.log.Error(err, "illegal push options")
+		return err
+	}
+	nme.sks = sks
+
 	nme.log.Info("register collectors")
-	return nme.register()
+	if err := nme.register(); err != nil {
+		return err
+	}
+	nme.registered = true
+	return nil
 }
 
-func (nme *nfsgMetricsExporter) serve() error {
-	addr := fmt.Sprintf(":%d", nme.port)
-	nme.log.Info("serve metrics", "addr", addr)
+// registerer returns the registerer of all collectors, which attaches self
+// pod labels to all metrics when enabled
+func (nme *nfsgMetricsExporter) registerer() prometheus.Registerer {
+	if nme.self != nil && nme.opts.SelfPodConstLabels {
+		return prometheus.WrapRegistererWith(nme.self.constLabels(), nme.reg)
+	}
+	return nme.reg
+}
 
-	handler := promhttp.HandlerFor(nme.reg, promhttp.HandlerOpts{})
-	nme.mux.Handle(DefaultMetricsPath, handler)
+// gatherer returns the gatherer of all metrics. Unless background polling
+// is enabled, it refreshes the stats snapshot once upon each gather, which is
+// then shared by all collectors
+func (nme *nfsgMetricsExporter) gatherer() prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		nme.snr.fresh()
+		return nme.reg.Gather()
+	})
+}
+
+func (nme *nfsgMetricsExporter) start(ctx context.Context) {
+	if nme.opts.PollInterval > 0 {
+		go nme.snr.poll(ctx)
+	}
+	nme.cle.start(ctx)
+	nme.vre.start(ctx)
+	nme.sks.start(ctx)
+}
+
+// handle registers all HTTP handlers on the exporter's mux
+func (nme *nfsgMetricsExporter) handle() {
+	nme.mux.Handle(DefaultMetricsPath, nme.sks.prom)
+	nme.handleHealth()
+	nme.handleLanding()
+	nme.handleAPI()
+	nme.handleDebug()
+	nme.handleAdmin()
+}
+
+func (nme *nfsgMetricsExporter) serve(ctx context.Context) error {
+	if len(nme.opts.MetricsAddrs) == 0 && nme.sks.enabled() {
+		nme.log.Info("metrics server disabled; push only")
+		<-ctx.Done()
+		return nil
+	}
+	nme.handle()
 
-	listener, err := net.Listen("tcp", addr)
+	listeners, err := nme.listen()
 	if err != nil {
-		nme.log.Error(err, "failed to listen", "addr", addr)
 		return err
 	}
-	defer listener.Close()
+	handler := nme.wgd.wrap(nme.mux, DefaultHealthzPath, DefaultReadyzPath)
+	server := &http.Server{Handler: handler}
+	if !nme.wgd.http2() {
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(l net.Listener) {
+			errs <- server.Serve(l)
+		}(listener)
+	}
+
+	select {
+	case err = <-errs:
+		nme.log.Error(err, "HTTP server failure")
+		_ = server.Close()
+		return err
+	case <-ctx.Done():
+	}
+	return nme.shutdown(server)
+}
+
+// listen creates listeners on all metrics addresses. TLS, when configured,
+// applies to TCP listeners only
+func (nme *nfsgMetricsExporter) listen() ([]net.Listener, error) {
+	tlsCfg := nme.wgd.tlsConfig()
+	listeners := []net.Listener{}
+	for _, addr := range nme.opts.MetricsAddrs {
+		la, err := parseListenAddr(addr)
+		if err != nil {
+			nme.log.Error(err, "illegal listen address", "addr", addr)
+			closeListeners(listeners)
+			return nil, err
+		}
+		listener, err := la.listen()
+		if err != nil {
+			nme.log.Error(err, "failed to listen", "addr", la.String())
+			closeListeners(listeners)
+			return nil, err
+		}
+		if tlsCfg != nil && la.network == "tcp" {
+			nme.log.Info("serve metrics over TLS", "addr", la.String())
+			listener = tls.NewListener(listener, tlsCfg)
+		} else {
+			nme.log.Info("serve metrics", "addr", la.String())
+		}
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("no listen address")
+	}
+	return listeners, nil
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
+
+// shutdown drains in-flight HTTP requests within the shutdown timeout
+func (nme *nfsgMetricsExporter) shutdown(server *http.Server) error {
+	nme.log.Info("shutdown metrics server", "timeout", nme.opts.ShutdownTimeout)
 
-	if err := http.Serve(listener, nme.mux); err != nil {
-		nme.log.Error(err, "HTTP server failure", "addr", addr)
+	ctx, cancel := context.WithTimeout(context.Background(), nme.opts.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		nme.log.Error(err, "HTTP server shutdown failure")
 		return err
 	}
 	return nil
 }
 
+func (nme *nfsgMetricsExporter) close() {
+	if nme.sks != nil {
+		nme.sks.wait()
+	}
+	nme.snr.close()
+	nme.snr.ext.stop()
+}
+
 // RunNfsgMetricsExporter executes an HTTP server and exports NFS-Ganesha
-// stats as Prometheus metrics.
-func RunNfsgMetricsExporter(log logr.Logger) error {
-	nme := newNfsgMetricsExporter(log, DefaultMetricsPort)
+// stats as Prometheus metrics, until the context is done.
+func RunNfsgMetricsExporter(ctx context.Context, log logr.Logger, opts *Options) error {
+	nme := newNfsgMetricsExporter(log, opts)
 	err := nme.init()
 	if err != nil {
 		return err
 	}
-	return nme.serve()
+	defer nme.close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	nme.start(ctx)
+	err = nme.serve(ctx)
+	if err != nil {
+		return err
+	}
+	nme.log.Info("metrics exporter stopped")
+	return nil
 }
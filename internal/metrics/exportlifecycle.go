@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// exportRetention is the period to keep reporting removed exports
+	exportRetention = time.Hour
+	// eventsComponent is the source component of Kubernetes Events
+	eventsComponent = "nfs-ganesha-metrics"
+)
+
+// nfsgExportSeen is the lifecycle record of a single export
+type nfsgExportSeen struct {
+	path      string
+	protos    string
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// nfsgExportTracker diffs export sets between snapshots, and reports
+// exports added, removed or changed by log and optionally Kubernetes Events
+type nfsgExportTracker struct {
+	nme      *nfsgMetricsExporter
+	mu       sync.Mutex
+	seen     map[uint32]*nfsgExportSeen
+	present  map[uint32]bool
+	baseline bool
+	added    uint64
+	removed  uint64
+	changed  uint64
+	bcast    record.EventBroadcaster
+	recorder record.EventRecorder
+	pod      *corev1.Pod
+}
+
+func newNfsgExportTracker(nme *nfsgMetricsExporter) *nfsgExportTracker {
+	return &nfsgExportTracker{
+		nme:     nme,
+		seen:    map[uint32]*nfsgExportSeen{},
+		present: map[uint32]bool{},
+	}
+}
+
+// startEvents records export changes as Kubernetes Events on the
+// exporter's own pod
+func (ext *nfsgExportTracker) startEvents() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clnt, err := ext.nme.getKClient()
+	if err != nil {
+		return err
+	}
+	pod, err := GetSelfPod(ctx, clnt)
+	if err != nil {
+		return err
+	}
+	bcast := record.NewBroadcaster()
+	bcast.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: clnt.ClientSet.CoreV1().Events(pod.Namespace),
+	})
+	ext.mu.Lock()
+	defer ext.mu.Unlock()
+	ext.bcast = bcast
+	ext.recorder = bcast.NewRecorder(scheme.Scheme,
+		corev1.EventSource{Component: eventsComponent})
+	ext.pod = pod
+	return nil
+}
+
+func (ext *nfsgExportTracker) stop() {
+	ext.mu.Lock()
+	defer ext.mu.Unlock()
+	if ext.bcast != nil {
+		ext.bcast.Shutdown()
+		ext.bcast = nil
+	}
+}
+
+// observe diffs the exports of a successful snapshot against the previous
+// one. The first snapshot is a baseline, which reports no changes
+func (ext *nfsgExportTracker) observe(snap *nfsgSnapshot) {
+	if snap.exportsErr != nil {
+		return
+	}
+	ext.mu.Lock()
+	defer ext.mu.Unlock()
+
+	present := make(map[uint32]bool, len(snap.exports))
+	for _, ent := range snap.exports {
+		id := ent.export.ExportID
+		path := ent.export.Path
+		protos := exportProtocols(&ent.export)
+		present[id] = true
+		seen, ok := ext.seen[id]
+		switch {
+		case !ok || !ext.present[id]:
+			ext.seen[id] = &nfsgExportSeen{
+				path:      path,
+				protos:    protos,
+				firstSeen: snap.time,
+				lastSeen:  snap.time,
+			}
+			if ext.baseline {
+				ext.added++
+				ext.report(id, path, "ExportAdded", "export added")
+			}
+		case seen.path != path || seen.protos != protos:
+			ext.changed++
+			ext.report(id, path, "ExportChanged", "export changed",
+				"prevpath", seen.path, "protocols", protos)
+			seen.path = path
+			seen.protos = protos
+			seen.lastSeen = snap.time
+		default:
+			seen.lastSeen = snap.time
+		}
+	}
+	for id, seen := range ext.seen {
+		if ext.present[id] && !present[id] {
+			ext.removed++
+			ext.report(id, seen.path, "ExportRemoved", "export removed")
+		}
+		if !present[id] && snap.time.Sub(seen.lastSeen) > exportRetention {
+			delete(ext.seen, id)
+		}
+	}
+	ext.present = present
+	ext.baseline = true
+}
+
+func (ext *nfsgExportTracker) report(id uint32, path, reason, msg string,
+	kvs ...interface{}) {
+	kvs = append([]interface{}{"exportid", id, "path", path}, kvs...)
+	ext.nme.log.Info(msg, kvs...)
+	if ext.recorder != nil {
+		ext.recorder.Eventf(ext.pod, corev1.EventTypeNormal, reason,
+			"NFS-Ganesha %s: id=%d path=%s", msg, id, path)
+	}
+}
+
+// nfsgExportLifecycleCollector exports the changes of NFS-Ganesha exports
+// over time
+type nfsgExportLifecycleCollector struct {
+	nfsgCollector
+}
+
+func (col *nfsgExportLifecycleCollector) Collect(ch chan<- prometheus.Metric) {
+	ext := col.nme.snr.ext
+	ext.mu.Lock()
+	defer ext.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[0], prometheus.CounterValue, float64(ext.added))
+
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[1], prometheus.CounterValue, float64(ext.removed))
+
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[2], prometheus.CounterValue, float64(ext.changed))
+
+	ids := make([]uint32, 0, len(ext.seen))
+	for id := range ext.seen {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		seen := ext.seen[id]
+		exportID := strconv.FormatUint(uint64(id), 10)
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[3], prometheus.GaugeValue,
+			float64(seen.firstSeen.UnixNano())/1e9, exportID, seen.path)
+
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[4], prometheus.GaugeValue,
+			float64(seen.lastSeen.UnixNano())/1e9, exportID, seen.path)
+	}
+}
+
+func (nme *nfsgMetricsExporter) newNfsgExportLifecycleCollector() prometheus.Collector {
+	col := &nfsgExportLifecycleCollector{}
+	col.nme = nme
+	col.name = "export_lifecycle"
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("export", "added_total"),
+			"Total number of exports added", []string{}, nil),
+		prometheus.NewDesc(
+			collectorName("export", "removed_total"),
+			"Total number of exports removed", []string{}, nil),
+		prometheus.NewDesc(
+			collectorName("export", "changed_total"),
+			"Total number of exports whose path or protocols changed", []string{}, nil),
+		prometheus.NewDesc(
+			collectorName("export", "first_seen_timestamp_seconds"),
+			"Time when export was first seen", []string{"exportid", "path"}, nil),
+		prometheus.NewDesc(
+			collectorName("export", "last_seen_timestamp_seconds"),
+			"Time when export was last seen", []string{"exportid", "path"}, nil),
+	}
+	return col
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// readyzTimeout bounds the DBus check of a readiness probe
+	readyzTimeout = 2 * time.Second
+	// readyzMaxPolls is the number of poll intervals after which the most
+	// recent successful snapshot is considered stale
+	readyzMaxPolls = 3
+)
+
+var (
+	// DefaultHealthzPath is the HTTP path of liveness probe
+	DefaultHealthzPath = "/healthz"
+	// DefaultReadyzPath is the HTTP path of readiness probe
+	DefaultReadyzPath = "/readyz"
+)
+
+func (nme *nfsgMetricsExporter) handleHealth() {
+	nme.mux.HandleFunc(DefaultHealthzPath, nme.serveHealthz)
+	nme.mux.HandleFunc(DefaultReadyzPath, nme.serveReadyz)
+}
+
+// serveHealthz reports liveness: reaching this handler implies that the
+// process is alive and its HTTP serving loop is responsive
+func (nme *nfsgMetricsExporter) serveHealthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+// serveReadyz reports readiness, with failure reasons in response body
+func (nme *nfsgMetricsExporter) serveReadyz(w http.ResponseWriter, _ *http.Request) {
+	reasons := nme.notReadyReasons()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(reasons) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(reasons, "\n"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+// notReadyReasons checks readiness without fetching stats: with background
+// polling, by the age and errors of the most recent snapshot; otherwise, by
+// a time-bounded check that NFS-Ganesha owns its DBus name
+func (nme *nfsgMetricsExporter) notReadyReasons() []string {
+	reasons := []string{}
+	if !nme.registered {
+		reasons = append(reasons, "collectors not registered")
+	}
+	if nme.opts.PollInterval == 0 {
+		if err := pingNfsGanesha(readyzTimeout); err != nil {
+			reasons = append(reasons, fmt.Sprintf("DBus unreachable: %v", err))
+		}
+		return reasons
+	}
+	snap := nme.snr.current()
+	if snap == nil {
+		reasons = append(reasons, "no stats poll yet")
+		return reasons
+	}
+	if snap.exportsErr != nil {
+		reasons = append(reasons,
+			fmt.Sprintf("exports DBus unreachable: %v", snap.exportsErr))
+	}
+	if snap.clientsErr != nil {
+		reasons = append(reasons,
+			fmt.Sprintf("clients DBus unreachable: %v", snap.clientsErr))
+	}
+	lastSuccess := nme.snr.stats().lastSuccess
+	maxAge := readyzMaxPolls * nme.opts.PollInterval
+	if lastSuccess.IsZero() {
+		reasons = append(reasons, "no successful stats poll yet")
+	} else if age := time.Since(lastSuccess); age > maxAge {
+		reasons = append(reasons,
+			fmt.Sprintf("last successful stats poll is stale: %v", age.Round(time.Second)))
+	}
+	return reasons
+}
+
+// pingNfsGanesha checks, over a dedicated DBus connection, that NFS-Ganesha
+// owns its bus name. It does not wait beyond timeout, even if the bus hangs
+func pingNfsGanesha(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		reader := NewExportsDbusReader()
+		if err := reader.Setup(); err != nil {
+			errs <- err
+			return
+		}
+		defer reader.Close()
+		_, err := reader.NameOwnerContext(ctx)
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
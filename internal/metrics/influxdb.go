@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	influxMaxDatagram = 1432
+)
+
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// nfsgInfluxSink pushes all gathered metrics to InfluxDB in line protocol,
+// either via HTTP write API or over UDP. Counters are written as
+// per-interval deltas
+type nfsgInfluxSink struct {
+	nme   *nfsgMetricsExporter
+	dts   *nfsgDeltas
+	url   string
+	token string
+	clnt  *http.Client
+	conn  net.Conn
+}
+
+func newNfsgInfluxSink(nme *nfsgMetricsExporter) (*nfsgInfluxSink, error) {
+	ifs := &nfsgInfluxSink{
+		nme:   nme,
+		dts:   newNfsgDeltas(),
+		url:   nme.opts.InfluxURL,
+		token: nme.opts.InfluxToken,
+	}
+	if strings.HasPrefix(ifs.url, "udp://") {
+		conn, err := net.Dial("udp", strings.TrimPrefix(ifs.url, "udp://"))
+		if err != nil {
+			return nil, err
+		}
+		ifs.conn = conn
+	} else if strings.HasPrefix(ifs.url, "http://") ||
+		strings.HasPrefix(ifs.url, "https://") {
+		ifs.clnt = &http.Client{}
+	} else {
+		return nil, fmt.Errorf("illegal InfluxDB URL: %s", ifs.url)
+	}
+	return ifs, nil
+}
+
+func (ifs *nfsgInfluxSink) sinkName() string {
+	return "influxdb"
+}
+
+func (ifs *nfsgInfluxSink) push(ctx context.Context, mfs []*dto.MetricFamily) error {
+	timestamp := strconv.FormatInt(time.Now().UnixNano(), 10)
+	samples := ifs.dts.apply(flattenMetrics(mfs))
+	lines := make([]string, 0, len(samples))
+	for i := range samples {
+		// line protocol has no representation of NaN or infinite values
+		if math.IsNaN(samples[i].value) || math.IsInf(samples[i].value, 0) {
+			continue
+		}
+		lines = append(lines, formatInfluxLine(&samples[i], timestamp))
+	}
+	if ifs.conn != nil {
+		return writeDatagrams(ifs.conn, lines, influxMaxDatagram)
+	}
+	return ifs.write(ctx, lines)
+}
+
+func (ifs *nfsgInfluxSink) write(ctx context.Context, lines []string) error {
+	body := strings.Join(lines, "\n")
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		ifs.url, bytes.NewReader([]byte(body)))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if ifs.token != "" {
+		hreq.Header.Set("Authorization", "Token "+ifs.token)
+	}
+	resp, err := ifs.clnt.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("InfluxDB write failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// formatInfluxLine returns a line-protocol line: "name,tag=value value=V T"
+func formatInfluxLine(s *nfsgSample, timestamp string) string {
+	name, labels := s.name()
+	var sb strings.Builder
+	sb.WriteString(influxEscaper.Replace(name))
+	for _, l := range labels {
+		sb.WriteByte(',')
+		sb.WriteString(influxEscaper.Replace(l.name))
+		sb.WriteByte('=')
+		sb.WriteString(influxEscaper.Replace(l.value))
+	}
+	sb.WriteString(" value=")
+	sb.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+	sb.WriteByte(' ')
+	sb.WriteString(timestamp)
+	return sb.String()
+}
+
+func (ifs *nfsgInfluxSink) close() {
+	if ifs.conn != nil {
+		ifs.conn.Close()
+	}
+	if ifs.clnt != nil {
+		ifs.clnt.CloseIdleConnections()
+	}
+}
@@ -94,3 +94,35 @@ func GetSelfPodID() types.NamespacedName {
 		Name:      os.Getenv(PodNameEnvKey),
 	}
 }
+
+func getPodNode(ctx context.Context, clnt *kclient,
+	pod *corev1.Pod) (*corev1.Node, error) {
+	if len(pod.Spec.NodeName) == 0 {
+		return nil, fmt.Errorf("pod %s/%s not scheduled",
+			pod.Namespace, pod.Name)
+	}
+	return clnt.ClientSet.CoreV1().
+		Nodes().Get(ctx, pod.Spec.NodeName, metav1.GetOptions{})
+}
+
+// getPodWorkload resolves the kind and name of the top-level controller
+// which owns a pod, following ReplicaSets up to their Deployments
+func getPodWorkload(ctx context.Context, clnt *kclient,
+	pod *corev1.Pod) (string, string, error) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", "", nil
+	}
+	if owner.Kind != "ReplicaSet" {
+		return owner.Kind, owner.Name, nil
+	}
+	rs, err := clnt.ClientSet.AppsV1().
+		ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+	if err != nil {
+		return owner.Kind, owner.Name, err
+	}
+	if rsOwner := metav1.GetControllerOf(rs); rsOwner != nil {
+		return rsOwner.Kind, rsOwner.Name, nil
+	}
+	return owner.Kind, owner.Name, nil
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"html/template"
+	"net/http"
+	goruntime "runtime"
+	"time"
+)
+
+// nfsgLandingInfo is the content of the exporter's landing page
+type nfsgLandingInfo struct {
+	Versions    Versions
+	GoVersion   string
+	Connected   bool
+	Errors      []string
+	Exports     int
+	Clients     int
+	LastSuccess string
+	Collectors  []string
+	Links       []nfsgLandingLink
+}
+
+// nfsgLandingLink is a single link on the landing page
+type nfsgLandingLink struct {
+	Path string
+	Text string
+}
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>NFS-Ganesha Metrics Exporter</title></head>
+<body>
+<h1>NFS-Ganesha Metrics Exporter</h1>
+<h2>Build</h2>
+<table>
+<tr><td>Version</td><td>{{.Versions.Version}}</td></tr>
+<tr><td>Commit</td><td>{{.Versions.CommitID}}</td></tr>
+<tr><td>Go</td><td>{{.GoVersion}}</td></tr>
+</table>
+<h2>NFS-Ganesha</h2>
+<table>
+<tr><td>Connected</td><td>{{.Connected}}</td></tr>
+<tr><td>Exports</td><td>{{.Exports}}</td></tr>
+<tr><td>Clients</td><td>{{.Clients}}</td></tr>
+<tr><td>Last success</td><td>{{.LastSuccess}}</td></tr>
+</table>
+{{range .Errors}}<p><code>{{.}}</code></p>
+{{end}}
+<h2>Collectors</h2>
+<ul>
+{{range .Collectors}}<li>{{.}}</li>
+{{end}}</ul>
+<h2>Links</h2>
+<ul>
+{{range .Links}}<li><a href="{{.Path}}">{{.Text}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+func (nme *nfsgMetricsExporter) handleLanding() {
+	nme.mux.HandleFunc("/", nme.serveLanding)
+}
+
+func (nme *nfsgMetricsExporter) serveLanding(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := landingTemplate.Execute(w, nme.landingInfo()); err != nil {
+		nme.log.Error(err, "failed to render landing page")
+	}
+}
+
+func (nme *nfsgMetricsExporter) landingInfo() *nfsgLandingInfo {
+	info := &nfsgLandingInfo{
+		Versions:    GetVersions(),
+		GoVersion:   goruntime.Version(),
+		LastSuccess: "never",
+		Collectors:  nme.collectors,
+		Links: []nfsgLandingLink{
+			{Path: DefaultMetricsPath, Text: "Metrics"},
+			{Path: DefaultHealthzPath, Text: "Liveness"},
+			{Path: DefaultReadyzPath, Text: "Readiness"},
+			{Path: DefaultAPIPrefix + "/exports", Text: "Exports (JSON)"},
+			{Path: DefaultAPIPrefix + "/clients", Text: "Clients (JSON)"},
+		},
+	}
+	if last := nme.snr.stats().lastSuccess; !last.IsZero() {
+		info.LastSuccess = last.Format(time.RFC3339)
+	}
+	if snap := nme.snr.current(); snap != nil {
+		info.Connected = !snap.failed()
+		info.Exports = len(snap.exports)
+		info.Clients = len(snap.clients)
+		for _, err := range []error{snap.exportsErr, snap.clientsErr} {
+			if err != nil {
+				info.Errors = append(info.Errors, err.Error())
+			}
+		}
+	}
+	if nme.opts.DebugEndpoints {
+		info.Links = append(info.Links,
+			nfsgLandingLink{Path: DefaultDebugDbusPath, Text: "DBus replies"},
+			nfsgLandingLink{Path: DefaultDebugPprofPath, Text: "Profiling"})
+	}
+	return info
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	unixAddrPrefix = "unix:"
+	tcpAddrPrefix  = "tcp://"
+)
+
+var (
+	// DefaultUnixSocketMode is the default file mode of Unix-domain sockets
+	DefaultUnixSocketMode = fs.FileMode(0660)
+)
+
+// nfsgListenAddr is a parsed listen address: either TCP "[tcp://]host:port"
+// or Unix-domain socket "unix:/path[?mode=0660]"
+type nfsgListenAddr struct {
+	network string
+	address string
+	mode    fs.FileMode
+}
+
+func (la *nfsgListenAddr) String() string {
+	if la.network == "unix" {
+		return unixAddrPrefix + la.address
+	}
+	return la.address
+}
+
+func parseListenAddr(s string) (*nfsgListenAddr, error) {
+	if !strings.HasPrefix(s, unixAddrPrefix) {
+		return &nfsgListenAddr{
+			network: "tcp",
+			address: strings.TrimPrefix(s, tcpAddrPrefix),
+		}, nil
+	}
+	la := &nfsgListenAddr{
+		network: "unix",
+		mode:    DefaultUnixSocketMode,
+	}
+	path := strings.TrimPrefix(s, unixAddrPrefix)
+	query := ""
+	if idx := strings.Index(path, "?"); idx >= 0 {
+		path, query = path[:idx], path[idx+1:]
+	}
+	if path == "" {
+		return nil, fmt.Errorf("missing socket path: %s", s)
+	}
+	la.address = path
+	vals, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if mode := vals.Get("mode"); mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("illegal socket mode: %s", mode)
+		}
+		la.mode = fs.FileMode(m)
+	}
+	return la, nil
+}
+
+// listen creates a listener on the given address. For Unix-domain sockets,
+// a stale socket file is removed and the new one is assigned its mode
+func (la *nfsgListenAddr) listen() (net.Listener, error) {
+	if la.network != "unix" {
+		return net.Listen(la.network, la.address)
+	}
+	st, err := os.Lstat(la.address)
+	if err == nil {
+		if st.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("not a socket: %s", la.address)
+		}
+		if err = os.Remove(la.address); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen(la.network, la.address)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(la.address, la.mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -33,6 +33,15 @@ type Export struct {
 	LastTime unix.Timespec
 }
 
+// ExportDetails Structure of the output of DisplayExport dbus call
+type ExportDetails struct {
+	ExportID   uint16
+	FullPath   string
+	PseudoPath string
+	Tag        string
+	Clients    []string
+}
+
 // OperationStats
 type OperationStats struct {
 	Total  uint64
@@ -93,3 +102,20 @@ type ClientIOs struct {
 	ReplyHeader
 	ClientIOStats
 }
+
+// StatsTypes are the types of NFS-Ganesha stats, in the order of
+// StatusStats dbus call reply
+var StatsTypes = []string{"nfs", "fsal", "v3_full", "v4_full", "auth", "client_all_ops"}
+
+// StatsState
+type StatsState struct {
+	Type    string
+	Enabled bool
+	Since   unix.Timespec
+}
+
+// StatsStatus
+type StatsStatus struct {
+	ReplyHeader
+	Stats []StatsState
+}
@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	// DefaultShutdownTimeout is the default period to wait for in-flight
+	// scrapes upon shutdown; shorter than the pod's termination grace period
+	DefaultShutdownTimeout = 8 * time.Second
+	// DefaultMaxConcurrentCalls is the default limit of in-flight DBus stats
+	// calls
+	DefaultMaxConcurrentCalls = 8
+	// DefaultPushInterval is the default period of pushing metrics to
+	// push-based sinks
+	DefaultPushInterval = 30 * time.Second
+	// DefaultRemoteWriteRetries is the default number of retries of a failed
+	// remote-write request
+	DefaultRemoteWriteRetries = 3
+	// DefaultRemoteWriteBufferSize is the default number of remote-write
+	// requests to keep in memory while the receiver is unreachable
+	DefaultRemoteWriteBufferSize = 10
+	// DefaultClientIdleThreshold is the default period of inactivity after
+	// which a client is considered idle
+	DefaultClientIdleThreshold = 5 * time.Minute
+	// DefaultExportClientsMax is the default limit of per-export client
+	// series
+	DefaultExportClientsMax = 1000
+)
+
+// Options defines the run-time configuration of the metrics exporter
+type Options struct {
+	// MetricsAddrs are the addresses on which to serve metrics: either TCP
+	// "host:port" or Unix-domain socket "unix:/path[?mode=0660]"
+	MetricsAddrs []string
+	// WebConfigFile is the path of TLS and basic-auth configuration file,
+	// in Prometheus exporter-toolkit web-config format
+	WebConfigFile string
+	// ShutdownTimeout is the maximal period to wait for in-flight scrapes
+	// upon shutdown
+	ShutdownTimeout time.Duration
+	// DebugEndpoints enables pprof and raw DBus replies HTTP endpoints
+	DebugEndpoints bool
+	// AdminEndpoints enables administrative HTTP endpoints, such as stats
+	// reset; requires basic authentication in WebConfigFile, AdminUsers and
+	// either TLS in WebConfigFile or a unix socket listener
+	AdminEndpoints bool
+	// AdminUsers is the list of basic-auth users allowed to call admin
+	// endpoints, apart from those allowed to scrape
+	AdminUsers []string
+	// PollInterval is the period of background DBus stats polling. When
+	// zero, stats are fetched synchronously upon each scrape
+	PollInterval time.Duration
+	// MaxConcurrentCalls limits the number of in-flight per-export and
+	// per-client DBus stats calls
+	MaxConcurrentCalls int
+	// EnableStats is a list of NFS-Ganesha stats types to enable when found
+	// disabled: "nfs", "fsal", "v3_full", "v4_full", "auth",
+	// "client_all_ops" or "all"
+	EnableStats []string
+	// ClientAllowCIDRs, when not empty, limits per-client series to clients
+	// within those networks
+	ClientAllowCIDRs []string
+	// ClientDenyCIDRs excludes clients within those networks from per-client
+	// series
+	ClientDenyCIDRs []string
+	// ClientTopN, when positive, limits per-client series to the N clients
+	// with highest throughput
+	ClientTopN int
+	// ClientAggregate defines how all allowed clients are aggregated, in
+	// addition to the top-N clients: one of "none", "other" (a single "all"
+	// series) or "cidr"
+	ClientAggregate string
+	// ClientAggregatePrefixV4 is the prefix length of IPv4 CIDR buckets
+	ClientAggregatePrefixV4 int
+	// ClientAggregatePrefixV6 is the prefix length of IPv6 CIDR buckets
+	ClientAggregatePrefixV6 int
+	// ClientIdleThreshold is the period of inactivity after which a client
+	// is counted as idle
+	ClientIdleThreshold time.Duration
+	// EnrichClientPods enables mapping of clients to Kubernetes pods
+	EnrichClientPods bool
+	// EnrichClientDNS enables reverse-DNS lookup of clients hostnames
+	EnrichClientDNS bool
+	// EnrichClientDNSTTL is the caching period of reverse-DNS lookups
+	EnrichClientDNSTTL time.Duration
+	// ResolveExportVolumes enables mapping of exports to Kubernetes
+	// PersistentVolumes and their claims, by the longest export path or
+	// pseudo path which prefixes the volume's path
+	ResolveExportVolumes bool
+	// ExportServers limits exports-to-volumes mapping to volumes whose NFS
+	// server is, or resolves to, one of those addresses. When empty, the
+	// addresses of the exporter's own pod and node are used
+	ExportServers []string
+	// ExportClients enables per-export clients series: connected clients
+	// matching a host, network or wildcard entry of each export's access list
+	ExportClients bool
+	// ExportClientsMax limits the total number of per-export client series;
+	// clients beyond it are counted but not exported
+	ExportClientsMax int
+	// ExportEvents enables Kubernetes Events on the exporter's own pod upon
+	// exports added, removed or changed
+	ExportEvents bool
+	// SelfPodInfo enables export of the metadata of the exporter's own pod
+	SelfPodInfo bool
+	// SelfPodConstLabels attaches the exporter's pod, namespace and node as
+	// constant labels to all metrics
+	SelfPodConstLabels bool
+	// SelfPodLabels is a list of self pod labels to export
+	SelfPodLabels []string
+	// SelfPodAnnotations is a list of self pod annotations to export
+	SelfPodAnnotations []string
+	// PushInterval is the period of pushing metrics to push-based sinks
+	PushInterval time.Duration
+	// OTLPEndpoint, when not empty, enables push of metrics to an
+	// OpenTelemetry receiver at this address
+	OTLPEndpoint string
+	// OTLPProtocol is the OTLP transport: either "grpc" or "http"
+	OTLPProtocol string
+	// OTLPInsecure disables TLS when pushing OTLP metrics
+	OTLPInsecure bool
+	// OTLPHeaders are extra "key=value" headers of OTLP push requests
+	OTLPHeaders []string
+	// RemoteWriteURL, when not empty, enables push of metrics to a
+	// Prometheus remote-write receiver at this URL
+	RemoteWriteURL string
+	// RemoteWriteRetries is the number of retries of a failed remote-write
+	// request within a single push
+	RemoteWriteRetries int
+	// RemoteWriteBufferSize is the maximal number of unsent remote-write
+	// requests to keep in memory; oldest ones are dropped first
+	RemoteWriteBufferSize int
+	// PushgatewayURL, when not empty, enables push of metrics to a
+	// Prometheus Pushgateway at this URL
+	PushgatewayURL string
+	// PushgatewayJob is the job label of Pushgateway grouping key
+	PushgatewayJob string
+	// PushgatewayInstance is the instance label of Pushgateway grouping key;
+	// defaults to the exporter's pod or host name
+	PushgatewayInstance string
+	// TextfileDir, when not empty, enables periodic writing of metrics into
+	// a file in this directory, for node_exporter's textfile collector
+	TextfileDir string
+	// TextfileName is the name of metrics file within TextfileDir
+	TextfileName string
+	// TextfileOneshot writes metrics into TextfileDir once and exits,
+	// without serving HTTP
+	TextfileOneshot bool
+	// StatsdAddr, when not empty, enables push of metrics to a DogStatsD
+	// agent at this UDP address
+	StatsdAddr string
+	// StatsdTags are extra "key:value" tags of all DogStatsD metrics
+	StatsdTags []string
+	// InfluxURL, when not empty, enables push of metrics in InfluxDB line
+	// protocol: either HTTP write API URL or "udp://host:port"
+	InfluxURL string
+	// InfluxToken is the authorization token of InfluxDB HTTP write API
+	InfluxToken string
+}
+
+// NewDefaultOptions returns exporter options with default values
+func NewDefaultOptions() *Options {
+	return &Options{
+		MetricsAddrs:       []string{fmt.Sprintf(":%d", DefaultMetricsPort)},
+		ShutdownTimeout:    DefaultShutdownTimeout,
+		PollInterval:       0,
+		MaxConcurrentCalls: DefaultMaxConcurrentCalls,
+		ClientAggregate:    ClientAggregateNone,
+
+		ClientAggregatePrefixV4: 24,
+		ClientAggregatePrefixV6: 64,
+		ClientIdleThreshold:     DefaultClientIdleThreshold,
+		ExportClientsMax:        DefaultExportClientsMax,
+		EnrichClientDNSTTL:      10 * time.Minute,
+		PushInterval:            DefaultPushInterval,
+		OTLPProtocol:            OTLPProtocolGRPC,
+		RemoteWriteRetries:      DefaultRemoteWriteRetries,
+		RemoteWriteBufferSize:   DefaultRemoteWriteBufferSize,
+		PushgatewayJob:          DefaultPushgatewayJob,
+		TextfileName:            DefaultTextfileName,
+	}
+}
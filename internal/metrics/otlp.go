@@ -0,0 +1,325 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// OTLPProtocolGRPC pushes OTLP metrics over gRPC
+	OTLPProtocolGRPC = "grpc"
+	// OTLPProtocolHTTP pushes OTLP metrics as protobuf over HTTP
+	OTLPProtocolHTTP = "http"
+
+	otlpHTTPMetricsPath = "/v1/metrics"
+	otlpScopeName       = "github.com/synarete/nfs-ganesha-metrics"
+	otlpServiceName     = "nfs-ganesha-metrics"
+)
+
+// nfsgOTLPClient sends OTLP metrics export requests to a receiver
+type nfsgOTLPClient interface {
+	export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error
+	close()
+}
+
+// nfsgOTLPSink pushes all gathered metrics to an OpenTelemetry receiver
+type nfsgOTLPSink struct {
+	nme     *nfsgMetricsExporter
+	clnt    nfsgOTLPClient
+	startNs uint64
+}
+
+func newNfsgOTLPSink(nme *nfsgMetricsExporter) (*nfsgOTLPSink, error) {
+	headers, err := parseHeaders(nme.opts.OTLPHeaders)
+	if err != nil {
+		return nil, err
+	}
+	var clnt nfsgOTLPClient
+	switch nme.opts.OTLPProtocol {
+	case OTLPProtocolGRPC:
+		clnt, err = newNfsgOTLPGRPCClient(nme.opts, headers)
+	case OTLPProtocolHTTP:
+		clnt, err = newNfsgOTLPHTTPClient(nme.opts, headers)
+	default:
+		err = fmt.Errorf("unknown OTLP protocol: %s", nme.opts.OTLPProtocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &nfsgOTLPSink{
+		nme:     nme,
+		clnt:    clnt,
+		startNs: uint64(time.Now().UnixNano()),
+	}, nil
+}
+
+func parseHeaders(kvs []string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, kv := range kvs {
+		idx := strings.Index(kv, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("illegal header: %s", kv)
+		}
+		headers[strings.TrimSpace(kv[:idx])] = strings.TrimSpace(kv[idx+1:])
+	}
+	return headers, nil
+}
+
+func (ots *nfsgOTLPSink) sinkName() string {
+	return "otlp"
+}
+
+func (ots *nfsgOTLPSink) push(ctx context.Context, mfs []*dto.MetricFamily) error {
+	req := ots.makeRequest(mfs, uint64(time.Now().UnixNano()))
+	return ots.clnt.export(ctx, req)
+}
+
+func (ots *nfsgOTLPSink) close() {
+	ots.clnt.close()
+}
+
+func (ots *nfsgOTLPSink) makeRequest(mfs []*dto.MetricFamily,
+	nowNs uint64) *colmetricspb.ExportMetricsServiceRequest {
+	metrics := make([]*metricspb.Metric, 0, len(mfs))
+	for _, mf := range mfs {
+		if m := ots.convert(mf, nowNs); m != nil {
+			metrics = append(metrics, m)
+		}
+	}
+	vers := GetVersions()
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{
+			{
+				Resource: &resourcepb.Resource{
+					Attributes: makeKeyValues(ots.nme.resourceAttributes()),
+				},
+				ScopeMetrics: []*metricspb.ScopeMetrics{
+					{
+						Scope: &commonpb.InstrumentationScope{
+							Name:    otlpScopeName,
+							Version: vers.Version,
+						},
+						Metrics: metrics,
+					},
+				},
+			},
+		},
+	}
+}
+
+// convert translates a Prometheus metric family into OTLP metric: counters
+// become cumulative monotonic sums and gauges or untyped become gauges
+func (ots *nfsgOTLPSink) convert(mf *dto.MetricFamily, nowNs uint64) *metricspb.Metric {
+	points := make([]*metricspb.NumberDataPoint, 0, len(mf.Metric))
+	for _, m := range mf.Metric {
+		val, ok := metricValue(mf.GetType(), m)
+		if !ok {
+			continue
+		}
+		attrs := map[string]string{}
+		for _, lp := range m.Label {
+			attrs[lp.GetName()] = lp.GetValue()
+		}
+		points = append(points, &metricspb.NumberDataPoint{
+			Attributes:        makeKeyValues(attrs),
+			StartTimeUnixNano: ots.startNs,
+			TimeUnixNano:      nowNs,
+			Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: val},
+		})
+	}
+	if len(points) == 0 {
+		return nil
+	}
+	metric := &metricspb.Metric{
+		Name:        mf.GetName(),
+		Description: mf.GetHelp(),
+	}
+	if mf.GetType() == dto.MetricType_COUNTER {
+		metric.Data = &metricspb.Metric_Sum{
+			Sum: &metricspb.Sum{
+				DataPoints:             points,
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			},
+		}
+	} else {
+		metric.Data = &metricspb.Metric_Gauge{
+			Gauge: &metricspb.Gauge{DataPoints: points},
+		}
+	}
+	return metric
+}
+
+func metricValue(typ dto.MetricType, m *dto.Metric) (float64, bool) {
+	switch typ {
+	case dto.MetricType_COUNTER:
+		return m.GetCounter().GetValue(), m.Counter != nil
+	case dto.MetricType_GAUGE:
+		return m.GetGauge().GetValue(), m.Gauge != nil
+	case dto.MetricType_UNTYPED:
+		return m.GetUntyped().GetValue(), m.Untyped != nil
+	}
+	return 0, false
+}
+
+func makeKeyValues(attrs map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, &commonpb.KeyValue{
+			Key: k,
+			Value: &commonpb.AnyValue{
+				Value: &commonpb.AnyValue_StringValue{StringValue: attrs[k]},
+			},
+		})
+	}
+	return kvs
+}
+
+// resourceAttributes returns OpenTelemetry resource attributes which
+// identify this exporter, from self pod info when available
+func (nme *nfsgMetricsExporter) resourceAttributes() map[string]string {
+	vers := GetVersions()
+	attrs := map[string]string{
+		"service.name":    otlpServiceName,
+		"service.version": vers.Version,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		attrs["host.name"] = hostname
+	}
+	id := GetSelfPodID()
+	if id.Name != "" {
+		attrs["k8s.pod.name"] = id.Name
+	}
+	if id.Namespace != "" {
+		attrs["k8s.namespace.name"] = id.Namespace
+	}
+	if self := nme.self; self != nil {
+		attrs["k8s.pod.name"] = self.pod
+		attrs["k8s.namespace.name"] = self.namespace
+		attrs["k8s.node.name"] = self.node
+		if self.workloadKind != "" {
+			kind := strings.ToLower(self.workloadKind)
+			attrs["k8s."+kind+".name"] = self.workload
+		}
+	}
+	return attrs
+}
+
+// nfsgOTLPGRPCClient sends OTLP requests over gRPC
+type nfsgOTLPGRPCClient struct {
+	conn    *grpc.ClientConn
+	clnt    colmetricspb.MetricsServiceClient
+	headers metadata.MD
+}
+
+func newNfsgOTLPGRPCClient(opts *Options,
+	headers map[string]string) (*nfsgOTLPGRPCClient, error) {
+	creds := insecure.NewCredentials()
+	if !opts.OTLPInsecure {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	conn, err := grpc.Dial(opts.OTLPEndpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &nfsgOTLPGRPCClient{
+		conn:    conn,
+		clnt:    colmetricspb.NewMetricsServiceClient(conn),
+		headers: metadata.New(headers),
+	}, nil
+}
+
+func (gc *nfsgOTLPGRPCClient) export(ctx context.Context,
+	req *colmetricspb.ExportMetricsServiceRequest) error {
+	ctx = metadata.NewOutgoingContext(ctx, gc.headers)
+	_, err := gc.clnt.Export(ctx, req)
+	return err
+}
+
+func (gc *nfsgOTLPGRPCClient) close() {
+	gc.conn.Close()
+}
+
+// nfsgOTLPHTTPClient sends OTLP requests as binary protobuf over HTTP
+type nfsgOTLPHTTPClient struct {
+	url     string
+	clnt    *http.Client
+	headers map[string]string
+}
+
+func newNfsgOTLPHTTPClient(opts *Options,
+	headers map[string]string) (*nfsgOTLPHTTPClient, error) {
+	url := opts.OTLPEndpoint
+	if !strings.Contains(url, "://") {
+		scheme := "https://"
+		if opts.OTLPInsecure {
+			scheme = "http://"
+		}
+		url = scheme + url
+	}
+	if !strings.HasSuffix(url, otlpHTTPMetricsPath) {
+		url = strings.TrimSuffix(url, "/") + otlpHTTPMetricsPath
+	}
+	return &nfsgOTLPHTTPClient{
+		url:     url,
+		clnt:    &http.Client{},
+		headers: headers,
+	}, nil
+}
+
+func (hc *nfsgOTLPHTTPClient) export(ctx context.Context,
+	req *colmetricspb.ExportMetricsServiceRequest) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		hc.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range hc.headers {
+		hreq.Header.Set(k, v)
+	}
+	resp, err := hc.clnt.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP HTTP push failed: %s", resp.Status)
+	}
+	return nil
+}
+
+func (hc *nfsgOTLPHTTPClient) close() {
+	hc.clnt.CloseIdleConnections()
+}
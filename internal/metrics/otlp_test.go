@@ -0,0 +1,278 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpReceiver is an in-process stand-in of an OTLP metrics receiver, which
+// records all export requests and their headers
+type otlpReceiver struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	mu      sync.Mutex
+	reqs    []*colmetricspb.ExportMetricsServiceRequest
+	headers []map[string]string
+}
+
+func (rcv *otlpReceiver) record(req *colmetricspb.ExportMetricsServiceRequest,
+	headers map[string]string) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.reqs = append(rcv.reqs, req)
+	rcv.headers = append(rcv.headers, headers)
+}
+
+func (rcv *otlpReceiver) Export(ctx context.Context,
+	req *colmetricspb.ExportMetricsServiceRequest) (
+	*colmetricspb.ExportMetricsServiceResponse, error) {
+	headers := map[string]string{}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, v := range md {
+		headers[k] = v[0]
+	}
+	rcv.record(req, headers)
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func (rcv *otlpReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != otlpHTTPMetricsPath ||
+		r.Header.Get("Content-Type") != "application/x-protobuf" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &colmetricspb.ExportMetricsServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rcv.record(req, map[string]string{"x-tenant": r.Header.Get("X-Tenant")})
+	w.WriteHeader(http.StatusOK)
+}
+
+func (rcv *otlpReceiver) last(t *testing.T) (*colmetricspb.ExportMetricsServiceRequest,
+	map[string]string) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if len(rcv.reqs) != 1 {
+		t.Fatalf("expected 1 export request, got %d", len(rcv.reqs))
+	}
+	return rcv.reqs[0], rcv.headers[0]
+}
+
+// newTestOTLPExporter returns an exporter with fixed metrics. Its snapshot
+// is seeded, and with background polling mode gather uses it as is, so that
+// it does not access DBus
+func newTestOTLPExporter(t *testing.T, opts *Options) *nfsgMetricsExporter {
+	t.Helper()
+	opts.PollInterval = time.Hour
+	opts.OTLPHeaders = []string{"X-Tenant=nfs"}
+	nme := newNfsgMetricsExporter(logr.Discard(), opts)
+	nme.snr.cur = &nfsgSnapshot{time: time.Now()}
+
+	ops := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "nfs_ganesha_test_ops_total",
+		Help: "Test operations",
+	}, []string{"exportid"})
+	ops.WithLabelValues("1").Add(7)
+	ops.WithLabelValues("2").Add(3)
+	clients := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "nfs_ganesha_test_clients",
+		Help: "Test clients",
+	})
+	clients.Set(5)
+	nme.reg.MustRegister(ops, clients)
+	return nme
+}
+
+// checkNoRefresh verifies that the exporter did not fetch stats over DBus
+func checkNoRefresh(t *testing.T, nme *nfsgMetricsExporter) {
+	if st := nme.snr.stats(); st.refreshes != 0 {
+		t.Errorf("unexpected stats refreshes: %d", st.refreshes)
+	}
+}
+
+func checkOTLPRequest(t *testing.T, req *colmetricspb.ExportMetricsServiceRequest) {
+	if len(req.ResourceMetrics) != 1 {
+		t.Fatalf("expected 1 resource metrics, got %d", len(req.ResourceMetrics))
+	}
+	rm := req.ResourceMetrics[0]
+	attrs := attributesOf(rm.Resource.Attributes)
+	if attrs["service.name"] != otlpServiceName {
+		t.Errorf("service.name: %q", attrs["service.name"])
+	}
+	if attrs["service.version"] != GetVersions().Version {
+		t.Errorf("service.version: %q", attrs["service.version"])
+	}
+	if hostname, _ := os.Hostname(); attrs["host.name"] != hostname {
+		t.Errorf("host.name: %q", attrs["host.name"])
+	}
+	if len(rm.ScopeMetrics) != 1 {
+		t.Fatalf("expected 1 scope metrics, got %d", len(rm.ScopeMetrics))
+	}
+	if name := rm.ScopeMetrics[0].Scope.Name; name != otlpScopeName {
+		t.Errorf("scope name: %q", name)
+	}
+	metrics := map[string]*metricspb.Metric{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+
+	ops := metrics["nfs_ganesha_test_ops_total"]
+	if ops == nil {
+		t.Fatal("missing counter metric")
+	}
+	sum := ops.GetSum()
+	if sum == nil || !sum.IsMonotonic || sum.AggregationTemporality !=
+		metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		t.Fatalf("counter not converted to cumulative monotonic sum: %v", ops)
+	}
+	values := map[string]float64{}
+	for _, dp := range sum.DataPoints {
+		values[attributesOf(dp.Attributes)["exportid"]] = dp.GetAsDouble()
+		if dp.StartTimeUnixNano == 0 || dp.TimeUnixNano < dp.StartTimeUnixNano {
+			t.Errorf("illegal data point times: %v", dp)
+		}
+	}
+	if values["1"] != 7 || values["2"] != 3 {
+		t.Errorf("counter values: %v", values)
+	}
+
+	clients := metrics["nfs_ganesha_test_clients"]
+	if clients == nil || clients.GetGauge() == nil {
+		t.Fatalf("gauge not converted to gauge: %v", clients)
+	}
+	if dps := clients.GetGauge().DataPoints; len(dps) != 1 || dps[0].GetAsDouble() != 5 {
+		t.Errorf("gauge data points: %v", dps)
+	}
+}
+
+func attributesOf(kvs []*commonpb.KeyValue) map[string]string {
+	attrs := map[string]string{}
+	for _, kv := range kvs {
+		attrs[kv.Key] = kv.Value.GetStringValue()
+	}
+	return attrs
+}
+
+func TestOTLPPushHTTP(t *testing.T) {
+	rcv := &otlpReceiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	opts := NewDefaultOptions()
+	opts.OTLPEndpoint = srv.URL
+	opts.OTLPProtocol = OTLPProtocolHTTP
+	nme := newTestOTLPExporter(t, opts)
+
+	sink, err := newNfsgOTLPSink(nme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.close()
+	mfs, err := nme.gatherer().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.push(context.Background(), mfs); err != nil {
+		t.Fatal(err)
+	}
+	checkNoRefresh(t, nme)
+	req, headers := rcv.last(t)
+	checkOTLPRequest(t, req)
+	if headers["x-tenant"] != "nfs" {
+		t.Errorf("headers: %v", headers)
+	}
+}
+
+func TestOTLPPushGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcv := &otlpReceiver{}
+	srv := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(srv, rcv)
+	go func() { _ = srv.Serve(listener) }()
+	defer srv.Stop()
+
+	opts := NewDefaultOptions()
+	opts.OTLPEndpoint = listener.Addr().String()
+	opts.OTLPProtocol = OTLPProtocolGRPC
+	opts.OTLPInsecure = true
+	nme := newTestOTLPExporter(t, opts)
+
+	sink, err := newNfsgOTLPSink(nme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	mfs, err := nme.gatherer().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.push(ctx, mfs); err != nil {
+		t.Fatal(err)
+	}
+	checkNoRefresh(t, nme)
+	req, headers := rcv.last(t)
+	checkOTLPRequest(t, req)
+	if headers["x-tenant"] != "nfs" {
+		t.Errorf("headers: %v", headers)
+	}
+}
+
+func TestOTLPPushOnStartAndShutdown(t *testing.T) {
+	rcv := &otlpReceiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	opts := NewDefaultOptions()
+	opts.OTLPEndpoint = srv.URL
+	opts.OTLPProtocol = OTLPProtocolHTTP
+	opts.PushInterval = time.Hour
+	nme := newTestOTLPExporter(t, opts)
+
+	sks, err := newNfsgSinks(nme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sks.start(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for sks.statsOf("otlp").pushes == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	sks.wait()
+
+	checkNoRefresh(t, nme)
+	st := sks.statsOf("otlp")
+	if st.pushes != 2 || st.failures != 0 {
+		t.Errorf("expected initial and final pushes: %+v", st)
+	}
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// ProbeCheck is the result of a single NFS-Ganesha health check. A check
+// with a warning passed, but reports a condition worth attention
+type ProbeCheck struct {
+	Name    string
+	OK      bool
+	Warning bool
+	Detail  string
+}
+
+// ProbeReport is the result of all NFS-Ganesha health checks
+type ProbeReport struct {
+	Checks []ProbeCheck
+}
+
+// OK returns true if all checks passed
+func (pr *ProbeReport) OK() bool {
+	for _, chk := range pr.Checks {
+		if !chk.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// Print writes a human-readable report of all checks
+func (pr *ProbeReport) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, chk := range pr.Checks {
+		result := "OK"
+		if !chk.OK {
+			result = "FAIL"
+		} else if chk.Warning {
+			result = "WARN"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", result, chk.Name, chk.Detail)
+	}
+	return tw.Flush()
+}
+
+func (pr *ProbeReport) add(name string, err error, detail string) bool {
+	if err != nil {
+		detail = err.Error()
+	}
+	pr.Checks = append(pr.Checks, ProbeCheck{Name: name, OK: err == nil, Detail: detail})
+	return err == nil
+}
+
+func (pr *ProbeReport) warn(name string, detail string) {
+	pr.Checks = append(pr.Checks,
+		ProbeCheck{Name: name, OK: true, Warning: true, Detail: detail})
+}
+
+// ProbeNfsGanesha verifies that NFS-Ganesha is reachable over DBus, owns its
+// bus name and provides the methods which the exporter requires. Disabled
+// NFS stats, which is NFS-Ganesha's default and may be enabled by the
+// exporter itself, fail the probe only if requireStats; otherwise they are
+// reported as a warning. Checks which depend on a failed one are skipped.
+func ProbeNfsGanesha(requireStats bool) *ProbeReport {
+	pr := &ProbeReport{}
+
+	exportsReader := NewExportsDbusReader()
+	if !pr.add("bus", exportsReader.Setup(), "connected to system bus") {
+		return pr
+	}
+	defer exportsReader.Close()
+
+	owner, err := exportsReader.NameOwner()
+	if !pr.add("name", err, nfsGaneshaDbusServicePrefix+" owned by "+owner) {
+		return pr
+	}
+
+	pr.probeMethods("exportmgr", &exportsReader.DbusReader,
+		exportsReader.mgrMethod("ShowExports"),
+		exportsReader.statsMethod("GetTotalOPS"))
+
+	clientsReader := NewClientsDbusReader()
+	if pr.add("clients-bus", clientsReader.Setup(), "connected to system bus") {
+		defer clientsReader.Close()
+		pr.probeMethods("clientmgr", &clientsReader.DbusReader,
+			clientsReader.mgrMethod("ShowClients"),
+			clientsReader.statsMethod("GetClientIOops"))
+	}
+
+	pr.probeStats(exportsReader, requireStats)
+	return pr
+}
+
+func (pr *ProbeReport) probeMethods(name string, dr *DbusReader, methods ...string) {
+	missing, err := dr.MissingMethods(methods...)
+	if err == nil && len(missing) > 0 {
+		err = fmt.Errorf("missing methods: %s", strings.Join(missing, ", "))
+	}
+	pr.add(name, err, fmt.Sprintf("%d methods available", len(methods)))
+}
+
+// probeStats checks that NFS stats are enabled; other stats types are
+// reported but optional. NFS-Ganesha versions without StatusStats pass with
+// a warning
+func (pr *ProbeReport) probeStats(exportsReader *ExportsDbusReader, requireStats bool) {
+	st, _, err := exportsReader.GetStatsStatus()
+	if isUnknownMethod(err) {
+		pr.warn("stats", "StatusStats not supported")
+		return
+	}
+	if err == nil && !st.Status {
+		err = fmt.Errorf("StatusStats: %s", st.Error)
+	}
+	if err != nil {
+		pr.add("stats", err, "")
+		return
+	}
+	enabled := []string{}
+	nfsEnabled := false
+	for _, ss := range st.Stats {
+		if ss.Enabled {
+			enabled = append(enabled, ss.Type)
+			nfsEnabled = nfsEnabled || ss.Type == "nfs"
+		}
+	}
+	detail := "enabled: " + strings.Join(enabled, ",")
+	switch {
+	case nfsEnabled:
+		pr.add("stats", nil, detail)
+	case requireStats:
+		pr.add("stats", errors.New("nfs stats disabled"), "")
+	default:
+		pr.warn("stats", "nfs stats disabled (see --enable-stats); "+detail)
+	}
+}
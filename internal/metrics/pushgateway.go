@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	// DefaultPushgatewayJob is the default job name of Pushgateway grouping key
+	DefaultPushgatewayJob = "nfs-ganesha-metrics"
+)
+
+// nfsgPushgatewaySink pushes all gathered metrics to a Prometheus
+// Pushgateway, replacing the metrics of its job/instance group
+type nfsgPushgatewaySink struct {
+	nme      *nfsgMetricsExporter
+	clnt     *http.Client
+	instance string
+}
+
+func newNfsgPushgatewaySink(nme *nfsgMetricsExporter) *nfsgPushgatewaySink {
+	return &nfsgPushgatewaySink{
+		nme:      nme,
+		clnt:     &http.Client{},
+		instance: nme.pushgatewayInstance(),
+	}
+}
+
+// pushgatewayInstance returns the instance of Pushgateway grouping key: the
+// explicit option, else the exporter's pod name, else its host name
+func (nme *nfsgMetricsExporter) pushgatewayInstance() string {
+	if nme.opts.PushgatewayInstance != "" {
+		return nme.opts.PushgatewayInstance
+	}
+	if nme.self != nil {
+		return nme.self.pod
+	}
+	if id := GetSelfPodID(); id.Name != "" {
+		return id.Name
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+func (pgs *nfsgPushgatewaySink) sinkName() string {
+	return "pushgateway"
+}
+
+func (pgs *nfsgPushgatewaySink) push(ctx context.Context, mfs []*dto.MetricFamily) error {
+	return push.New(pgs.nme.opts.PushgatewayURL, pgs.nme.opts.PushgatewayJob).
+		Gatherer(gathered(mfs, nil)).
+		Grouping("instance", pgs.instance).
+		Client(&nfsgContextDoer{ctx: ctx, clnt: pgs.clnt}).
+		Push()
+}
+
+func (pgs *nfsgPushgatewaySink) close() {
+	pgs.clnt.CloseIdleConnections()
+}
+
+// nfsgContextDoer binds HTTP requests of Pushgateway pusher to a context
+type nfsgContextDoer struct {
+	ctx  context.Context
+	clnt *http.Client
+}
+
+func (cd *nfsgContextDoer) Do(req *http.Request) (*http.Response, error) {
+	return cd.clnt.Do(req.WithContext(cd.ctx))
+}
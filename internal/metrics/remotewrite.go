@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	remoteWriteVersion = "0.1.0"
+	remoteWriteBackoff = 500 * time.Millisecond
+)
+
+// nfsgLabel is a single name-value pair of a time series
+type nfsgLabel struct {
+	name  string
+	value string
+}
+
+// nfsgSample is a single time series sample, with its labels sorted by name
+type nfsgSample struct {
+	labels  []nfsgLabel
+	value   float64
+	counter bool
+}
+
+// nfsgRemoteWriteSink pushes all gathered metrics to a Prometheus
+// remote-write receiver. Requests which failed are kept in a bounded
+// in-memory buffer and re-sent first upon next push
+type nfsgRemoteWriteSink struct {
+	nme    *nfsgMetricsExporter
+	url    string
+	clnt   *http.Client
+	buffer [][]byte
+}
+
+func newNfsgRemoteWriteSink(nme *nfsgMetricsExporter) *nfsgRemoteWriteSink {
+	return &nfsgRemoteWriteSink{
+		nme:  nme,
+		url:  nme.opts.RemoteWriteURL,
+		clnt: &http.Client{},
+	}
+}
+
+func (rws *nfsgRemoteWriteSink) sinkName() string {
+	return "remote_write"
+}
+
+func (rws *nfsgRemoteWriteSink) push(ctx context.Context, mfs []*dto.MetricFamily) error {
+	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+	rws.enqueue(snappy.Encode(nil, encodeWriteRequest(flattenMetrics(mfs), timestamp)))
+
+	var err error
+	for len(rws.buffer) > 0 {
+		retry, serr := rws.send(ctx, rws.buffer[0])
+		if serr != nil && retry {
+			return serr
+		}
+		if serr != nil {
+			rws.nme.log.Error(serr, "drop rejected remote-write request")
+			rws.nme.sks.addDropped(rws.sinkName(), 1)
+			err = serr
+		}
+		rws.buffer = rws.buffer[1:]
+	}
+	return err
+}
+
+// enqueue appends an encoded request to the buffer, dropping the oldest
+// requests beyond buffer capacity
+func (rws *nfsgRemoteWriteSink) enqueue(req []byte) {
+	rws.buffer = append(rws.buffer, req)
+	limit := rws.nme.opts.RemoteWriteBufferSize
+	if limit < 1 {
+		limit = 1
+	}
+	if drop := len(rws.buffer) - limit; drop > 0 {
+		rws.nme.log.Info("drop buffered remote-write requests", "count", drop)
+		rws.nme.sks.addDropped(rws.sinkName(), drop)
+		rws.buffer = rws.buffer[drop:]
+	}
+}
+
+// send posts a single request, retrying with exponential backoff upon
+// network errors, throttling and server-side failures. It returns false
+// upon failures which are not worth retrying, such as rejected requests
+func (rws *nfsgRemoteWriteSink) send(ctx context.Context, req []byte) (bool, error) {
+	backoff := remoteWriteBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := rws.post(ctx, req)
+		if err == nil || !retry || attempt >= rws.nme.opts.RemoteWriteRetries {
+			return retry, err
+		}
+		select {
+		case <-ctx.Done():
+			return retry, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (rws *nfsgRemoteWriteSink) post(ctx context.Context, req []byte) (bool, error) {
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		rws.url, bytes.NewReader(req))
+	if err != nil {
+		return false, err
+	}
+	hreq.Header.Set("Content-Type", "application/x-protobuf")
+	hreq.Header.Set("Content-Encoding", "snappy")
+	hreq.Header.Set("User-Agent", otlpServiceName+"/"+GetVersions().Version)
+	hreq.Header.Set("X-Prometheus-Remote-Write-Version", remoteWriteVersion)
+	resp, err := rws.clnt.Do(hreq)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("remote-write failed: %s", resp.Status)
+}
+
+func (rws *nfsgRemoteWriteSink) close() {
+	if len(rws.buffer) > 0 {
+		rws.nme.log.Info("discard buffered remote-write requests",
+			"count", len(rws.buffer))
+	}
+	rws.clnt.CloseIdleConnections()
+}
+
+// flattenMetrics converts metric families into plain time series samples,
+// expanding summaries and histograms into their component series
+func flattenMetrics(mfs []*dto.MetricFamily) []nfsgSample {
+	samples := []nfsgSample{}
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			add := func(suffix string, value float64, extra ...nfsgLabel) {
+				sample := makeSample(name+suffix, m, value, extra)
+				sample.counter = mf.GetType() == dto.MetricType_COUNTER || suffix != ""
+				samples = append(samples, sample)
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					add("", q.GetValue(), nfsgLabel{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.Bucket {
+					add("_bucket", float64(b.GetCumulativeCount()),
+						nfsgLabel{"le", formatFloat(b.GetUpperBound())})
+				}
+				add("_bucket", float64(h.GetSampleCount()), nfsgLabel{"le", "+Inf"})
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return samples
+}
+
+func makeSample(name string, m *dto.Metric, value float64, extra []nfsgLabel) nfsgSample {
+	labels := make([]nfsgLabel, 0, len(m.Label)+len(extra)+1)
+	labels = append(labels, nfsgLabel{"__name__", name})
+	for _, lp := range m.Label {
+		labels = append(labels, nfsgLabel{lp.GetName(), lp.GetValue()})
+	}
+	labels = append(labels, extra...)
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+	return nfsgSample{labels: labels, value: value}
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes samples as remote-write protobuf WriteRequest:
+// repeated TimeSeries (1), each of repeated Label (1) and Sample (2)
+func encodeWriteRequest(samples []nfsgSample, timestamp int64) []byte {
+	var req []byte
+	for _, s := range samples {
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sb)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
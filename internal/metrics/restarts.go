@@ -0,0 +1,335 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// defaultClockTicks is the kernel's USER_HZ on most architectures, in
+	// case it is not reported by the auxiliary vector
+	defaultClockTicks = 100
+	// auxvClockTicks is the AT_CLKTCK type of auxiliary vector entries
+	auxvClockTicks = 17
+
+	// ganeshaProcName is the command name of NFS-Ganesha's process
+	ganeshaProcName = "ganesha.nfsd"
+)
+
+// fetchOwner resolves the current owner of NFS-Ganesha's bus name, which
+// changes whenever NFS-Ganesha restarts
+func (snr *nfsgSnapshotter) fetchOwner(snap *nfsgSnapshot) {
+	if snap.exportsErr != nil {
+		return
+	}
+	reader, err := snr.getExportsReader()
+	if err != nil {
+		return
+	}
+	owner, err := reader.NameOwner()
+	if err != nil {
+		snr.nme.log.Error(err, "GetNameOwner")
+		return
+	}
+	snap.owner = owner
+	snap.ownerPID, _ = reader.OwnerProcessID(owner)
+}
+
+// nfsgRestartTracker detects NFS-Ganesha restarts, by change of its bus
+// name owner, and stats resets, by change of the stats timestamp reported
+// in stats replies or by decreasing counters
+type nfsgRestartTracker struct {
+	mu         sync.Mutex
+	owner      string
+	startTime  time.Time
+	restarts   uint64
+	resets     uint64
+	resetTime  time.Time
+	statsStamp unix.Timespec
+	exportOps  map[uint32]uint64
+	clientOps  map[string]uint64
+}
+
+func newNfsgRestartTracker() *nfsgRestartTracker {
+	return &nfsgRestartTracker{}
+}
+
+func (rst *nfsgRestartTracker) observe(snap *nfsgSnapshot) {
+	rst.mu.Lock()
+	defer rst.mu.Unlock()
+
+	restarted := rst.observeOwner(snap)
+	prevStamp := rst.statsStamp
+	stampChanged := rst.observeStatsStamp(snap)
+	countersReset := rst.observeCounters(snap)
+	if restarted {
+		rst.restarts++
+	}
+	if restarted || countersReset || (stampChanged && prevStamp.Sec != 0) {
+		rst.resets++
+		if !stampChanged {
+			rst.resetTime = snap.time
+		}
+	}
+}
+
+func (rst *nfsgRestartTracker) observeOwner(snap *nfsgSnapshot) bool {
+	if snap.owner == "" || snap.owner == rst.owner {
+		return false
+	}
+	restarted := rst.owner != ""
+	rst.owner = snap.owner
+	rst.startTime = time.Time{}
+	if start, err := processStartTime(snap.ownerPID); err == nil {
+		rst.startTime = start
+	} else if restarted {
+		rst.startTime = snap.time
+	}
+	return restarted
+}
+
+// observeStatsStamp tracks the time since which NFS-Ganesha collects stats,
+// as reported by each stats reply, and returns true if it changed; it moves
+// forward upon ResetStats
+func (rst *nfsgRestartTracker) observeStatsStamp(snap *nfsgSnapshot) bool {
+	stamp := unix.Timespec{}
+	for _, ent := range snap.exports {
+		if ent.stats != nil && ent.stats.Time.Sec > 0 {
+			stamp = ent.stats.Time
+			break
+		}
+	}
+	if stamp.Sec == 0 || stamp == rst.statsStamp {
+		return false
+	}
+	rst.statsStamp = stamp
+	rst.resetTime = time.Unix(stamp.Sec, stamp.Nsec)
+	return true
+}
+
+// observeCounters detects a reset by most exports' and clients' total ops
+// counts decreasing since the previous snapshot. A single decreasing series,
+// such as of a removed and re-added export or a reconnected client, is not
+// a reset of all stats
+func (rst *nfsgRestartTracker) observeCounters(snap *nfsgSnapshot) bool {
+	compared := 0
+	decreased := 0
+	observe := func(cur, prev uint64, ok bool) {
+		if !ok {
+			return
+		}
+		compared++
+		if cur < prev {
+			decreased++
+		}
+	}
+	if snap.exportsErr == nil {
+		ops := make(map[uint32]uint64, len(snap.exports))
+		for _, ent := range snap.exports {
+			if ent.stats == nil {
+				continue
+			}
+			id := ent.export.ExportID
+			ops[id] = totalOps(&ent.stats.OPS)
+			prev, ok := rst.exportOps[id]
+			observe(ops[id], prev, ok)
+		}
+		rst.exportOps = ops
+	}
+	if snap.clientsErr == nil {
+		ops := make(map[string]uint64, len(snap.clients))
+		for _, ent := range snap.clients {
+			if ent.ios == nil {
+				continue
+			}
+			sum := sumIOStats(&ent.ios.ClientIOStats)
+			ip := ent.client.Client
+			ops[ip] = ioOps(&sum)
+			prev, ok := rst.clientOps[ip]
+			observe(ops[ip], prev, ok)
+		}
+		rst.clientOps = ops
+	}
+	return decreased > 1 && 2*decreased > compared
+}
+
+// nfsgRestartStats is a copy of restart tracker's state
+type nfsgRestartStats struct {
+	startTime time.Time
+	restarts  uint64
+	resets    uint64
+	resetTime time.Time
+}
+
+func (rst *nfsgRestartTracker) stats() nfsgRestartStats {
+	rst.mu.Lock()
+	defer rst.mu.Unlock()
+	return nfsgRestartStats{
+		startTime: rst.startTime,
+		restarts:  rst.restarts,
+		resets:    rst.resets,
+		resetTime: rst.resetTime,
+	}
+}
+
+// processStartTime returns the start time of NFS-Ganesha's process, from
+// /proc. The bus owner's pid is of the host's PID namespace, thus it is
+// valid only when sharing it (hostPID); otherwise it may be of an unrelated
+// process, which the command name check rejects
+func processStartTime(pid uint32) (time.Time, error) {
+	if pid == 0 {
+		return time.Time{}, fmt.Errorf("unknown pid")
+	}
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if name := strings.TrimSpace(string(comm)); name != ganeshaProcName {
+		return time.Time{}, fmt.Errorf("pid %d is %s, not %s", pid, name, ganeshaProcName)
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return time.Time{}, err
+	}
+	// skip "pid (comm)" as comm may contain spaces
+	idx := strings.LastIndexByte(string(stat), ')')
+	if idx < 0 {
+		return time.Time{}, fmt.Errorf("illegal stat of pid %d", pid)
+	}
+	fields := strings.Fields(string(stat[idx+1:]))
+	// starttime is field 22 of stat; fields here start from field 3
+	if len(fields) < 20 {
+		return time.Time{}, fmt.Errorf("illegal stat of pid %d", pid)
+	}
+	ticks, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	btime, err := bootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	offset := time.Duration(ticks) * time.Second / time.Duration(clockTicks())
+	return btime.Add(offset), nil
+}
+
+var (
+	procClockTicks     uint64
+	procClockTicksOnce sync.Once
+)
+
+// clockTicks returns the kernel's USER_HZ, in which /proc reports times, as
+// sysconf(_SC_CLK_TCK) does: from the AT_CLKTCK entry of the process'
+// auxiliary vector
+func clockTicks() uint64 {
+	procClockTicksOnce.Do(func() {
+		procClockTicks = defaultClockTicks
+		auxv, err := os.ReadFile("/proc/self/auxv")
+		if err != nil {
+			return
+		}
+		word := strconv.IntSize / 8
+		for i := 0; i+2*word <= len(auxv); i += 2 * word {
+			typ := auxvWord(auxv[i : i+word])
+			val := auxvWord(auxv[i+word : i+2*word])
+			if typ == auxvClockTicks && val > 0 {
+				procClockTicks = val
+				return
+			}
+		}
+	})
+	return procClockTicks
+}
+
+// auxvWord decodes a native-endian word of the auxiliary vector
+func auxvWord(b []byte) uint64 {
+	if len(b) == 4 {
+		return uint64(nativeEndian().Uint32(b))
+	}
+	return nativeEndian().Uint64(b)
+}
+
+func nativeEndian() binary.ByteOrder {
+	one := uint16(1)
+	if *(*byte)(unsafe.Pointer(&one)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "btime ") {
+			sec, err := strconv.ParseInt(strings.TrimSpace(line[6:]), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(sec, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no btime in /proc/stat")
+}
+
+// nfsgRestartsCollector exports NFS-Ganesha's start time, restarts and stats
+// resets, so that rate computations can account for counter resets
+type nfsgRestartsCollector struct {
+	nfsgCollector
+}
+
+func (col *nfsgRestartsCollector) Collect(ch chan<- prometheus.Metric) {
+	st := col.nme.snr.rst.stats()
+	if !st.resetTime.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[0], prometheus.GaugeValue, float64(st.resetTime.UnixNano())/1e9)
+	}
+	if !st.startTime.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[1], prometheus.GaugeValue, float64(st.startTime.UnixNano())/1e9)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[2], prometheus.CounterValue, float64(st.restarts))
+
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[3], prometheus.CounterValue, float64(st.resets))
+}
+
+func (nme *nfsgMetricsExporter) newNfsgRestartsCollector() prometheus.Collector {
+	col := &nfsgRestartsCollector{}
+	col.nme = nme
+	col.name = "restarts"
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("stats", "reset_timestamp_seconds"),
+			"Time since which NFS-Ganesha stats are collected", []string{}, nil),
+		prometheus.NewDesc(
+			collectorName("", "start_time_seconds"),
+			"Start time of NFS-Ganesha process; requires host PID namespace",
+			[]string{}, nil),
+		prometheus.NewDesc(
+			collectorName("", "restarts_total"),
+			"Total number of detected NFS-Ganesha restarts", []string{}, nil),
+		prometheus.NewDesc(
+			collectorName("stats", "resets_total"),
+			"Total number of detected NFS-Ganesha stats resets", []string{}, nil),
+	}
+	return col
+}
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	nodeZoneLabelKey = "topology.kubernetes.io/zone"
+)
+
+// nfsgSelfInfo holds the metadata of the exporter's own pod, so that
+// metrics remain attributable without Prometheus pod relabeling
+type nfsgSelfInfo struct {
+	pod          string
+	namespace    string
+	node         string
+	zone         string
+	workloadKind string
+	workload     string
+	extraNames   []string
+	extraValues  []string
+}
+
+// resolveSelfInfo fetches the exporter's own pod, node and owning workload,
+// and selected labels and annotations of its pod
+func (nme *nfsgMetricsExporter) resolveSelfInfo() (*nfsgSelfInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clnt, err := nme.getKClient()
+	if err != nil {
+		return nil, err
+	}
+	pod, err := GetSelfPod(ctx, clnt)
+	if err != nil {
+		return nil, err
+	}
+	info := &nfsgSelfInfo{
+		pod:       pod.Name,
+		namespace: pod.Namespace,
+		node:      pod.Spec.NodeName,
+	}
+	node, err := getPodNode(ctx, clnt, pod)
+	if err != nil {
+		nme.log.Error(err, "failed to get self node", "node", info.node)
+	} else {
+		info.zone = node.Labels[nodeZoneLabelKey]
+	}
+	info.workloadKind, info.workload, err = getPodWorkload(ctx, clnt, pod)
+	if err != nil {
+		nme.log.Error(err, "failed to resolve self workload")
+	}
+	err = info.addExtra("label_", nme.opts.SelfPodLabels, pod.Labels)
+	if err != nil {
+		return nil, err
+	}
+	err = info.addExtra("annotation_", nme.opts.SelfPodAnnotations, pod.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// addExtra appends the selected keys as labels. Keys which sanitize to the
+// same label name are rejected
+func (info *nfsgSelfInfo) addExtra(prefix string, keys []string,
+	kvs map[string]string) error {
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	for _, key := range sorted {
+		name := prefix + sanitizeLabelName(key)
+		for _, other := range info.extraNames {
+			if other == name {
+				return fmt.Errorf("duplicate label name %s of key %s", name, key)
+			}
+		}
+		info.extraNames = append(info.extraNames, name)
+		info.extraValues = append(info.extraValues, kvs[key])
+	}
+	return nil
+}
+
+func sanitizeLabelName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// constLabels returns the labels to attach to all exported metrics
+func (info *nfsgSelfInfo) constLabels() prometheus.Labels {
+	return prometheus.Labels{
+		"exporter_pod":       info.pod,
+		"exporter_namespace": info.namespace,
+		"exporter_node":      info.node,
+	}
+}
+
+// nfsgSelfInfoCollector exports the metadata of the exporter's own pod
+type nfsgSelfInfoCollector struct {
+	nfsgCollector
+	info *nfsgSelfInfo
+}
+
+func (col *nfsgSelfInfoCollector) Collect(ch chan<- prometheus.Metric) {
+	info := col.info
+	values := []string{
+		info.pod,
+		info.namespace,
+		info.node,
+		info.zone,
+		info.workloadKind,
+		info.workload,
+	}
+	values = append(values, info.extraValues...)
+	ch <- prometheus.MustNewConstMetric(
+		col.dsc[0], prometheus.GaugeValue, 1, values...)
+}
+
+func (nme *nfsgMetricsExporter) newNfsgSelfInfoCollector(
+	info *nfsgSelfInfo) prometheus.Collector {
+	col := &nfsgSelfInfoCollector{info: info}
+	col.nme = nme
+	col.name = "self_info"
+	names := []string{
+		"pod",
+		"namespace",
+		"node",
+		"zone",
+		"workload_kind",
+		"workload",
+	}
+	names = append(names, info.extraNames...)
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("exporter", "pod_info"),
+			"Metadata of metrics-exporter pod", names, nil),
+	}
+	return col
+}
@@ -0,0 +1,395 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// nfsgSink is an output of gathered metrics: either the Prometheus scrape
+// endpoint, or a push-based output driven periodically
+type nfsgSink interface {
+	// sinkName returns a short name of the sink
+	sinkName() string
+	// push sends gathered metrics to the sink's destination
+	push(ctx context.Context, mfs []*dto.MetricFamily) error
+	// close releases any resources held by the sink
+	close()
+}
+
+// gathered returns a gatherer of already gathered metrics
+func gathered(mfs []*dto.MetricFamily, err error) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return mfs, err
+	})
+}
+
+// nfsgPromSink is the Prometheus scrape endpoint as a sink: the push loop
+// fans out each gather to it along with all other sinks, and scrapes are
+// served from the most recent one. Without a push loop, or when its metrics
+// are older than the push interval, it gathers upon each scrape by itself
+type nfsgPromSink struct {
+	sks  *nfsgSinks
+	mu   sync.Mutex
+	mfs  []*dto.MetricFamily
+	time time.Time
+}
+
+func (ps *nfsgPromSink) sinkName() string {
+	return "prometheus"
+}
+
+func (ps *nfsgPromSink) push(_ context.Context, mfs []*dto.MetricFamily) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.mfs = mfs
+	ps.time = time.Now()
+	return nil
+}
+
+func (ps *nfsgPromSink) close() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.mfs = nil
+}
+
+// latest returns the most recently pushed metrics, if still fresh
+func (ps *nfsgPromSink) latest() ([]*dto.MetricFamily, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.mfs == nil || time.Since(ps.time) > ps.sks.nme.opts.PushInterval {
+		return nil, false
+	}
+	return ps.mfs, true
+}
+
+func (ps *nfsgPromSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mfs, ok := ps.latest()
+	var err error
+	if !ok {
+		mfs, err = ps.sks.nme.gatherer().Gather()
+	}
+	promhttp.HandlerFor(gathered(mfs, err), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// nfsgSinkStats holds book-keeping information of a single sink
+type nfsgSinkStats struct {
+	pushes      uint64
+	failures    uint64
+	dropped     uint64
+	lastSuccess time.Time
+}
+
+// nfsgSinks drives all push-based outputs by a single push loop, which also
+// feeds the Prometheus scrape endpoint
+type nfsgSinks struct {
+	nme   *nfsgMetricsExporter
+	prom  *nfsgPromSink
+	sinks []nfsgSink
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	stats map[string]*nfsgSinkStats
+}
+
+func newNfsgSinks(nme *nfsgMetricsExporter) (*nfsgSinks, error) {
+	sks := &nfsgSinks{
+		nme:   nme,
+		stats: map[string]*nfsgSinkStats{},
+	}
+	sks.prom = &nfsgPromSink{sks: sks}
+	if nme.opts.OTLPEndpoint != "" {
+		sink, err := newNfsgOTLPSink(nme)
+		if err != nil {
+			return nil, err
+		}
+		sks.add(sink)
+	}
+	if nme.opts.RemoteWriteURL != "" {
+		sks.add(newNfsgRemoteWriteSink(nme))
+	}
+	if nme.opts.PushgatewayURL != "" {
+		sks.add(newNfsgPushgatewaySink(nme))
+	}
+	if nme.opts.TextfileDir != "" {
+		sks.add(newNfsgTextfileSink(nme))
+	}
+	if nme.opts.StatsdAddr != "" {
+		sink, err := newNfsgStatsdSink(nme)
+		if err != nil {
+			return nil, err
+		}
+		sks.add(sink)
+	}
+	if nme.opts.InfluxURL != "" {
+		sink, err := newNfsgInfluxSink(nme)
+		if err != nil {
+			return nil, err
+		}
+		sks.add(sink)
+	}
+	return sks, nil
+}
+
+func (sks *nfsgSinks) add(sink nfsgSink) {
+	sks.sinks = append(sks.sinks, sink)
+	sks.stats[sink.sinkName()] = &nfsgSinkStats{}
+}
+
+func (sks *nfsgSinks) enabled() bool {
+	return len(sks.sinks) > 0
+}
+
+// start launches the periodic push loop of all sinks
+func (sks *nfsgSinks) start(ctx context.Context) {
+	if !sks.enabled() {
+		return
+	}
+	sks.wg.Add(1)
+	go sks.run(ctx)
+}
+
+// wait blocks until the push loop is done
+func (sks *nfsgSinks) wait() {
+	sks.wg.Wait()
+}
+
+// run gathers metrics once per interval and pushes them to all sinks. It
+// pushes once upon start, and a final time upon shutdown
+func (sks *nfsgSinks) run(ctx context.Context) {
+	defer sks.wg.Done()
+	defer func() {
+		for _, sink := range sks.sinks {
+			sink.close()
+		}
+		sks.prom.close()
+	}()
+
+	interval := sks.nme.opts.PushInterval
+	for _, sink := range sks.sinks {
+		sks.nme.log.Info("start pushing metrics",
+			"sink", sink.sinkName(), "interval", interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	sks.pushAll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			sks.flush()
+			return
+		case <-ticker.C:
+			sks.pushAll(ctx)
+		}
+	}
+}
+
+// flush pushes final metrics upon shutdown, within the shutdown timeout
+func (sks *nfsgSinks) flush() {
+	ctx, cancel := context.WithTimeout(context.Background(),
+		sks.nme.opts.ShutdownTimeout)
+	defer cancel()
+
+	sks.pushAll(ctx)
+}
+
+// pushAll gathers metrics once and pushes them to all sinks concurrently.
+// Complete gathers are also pushed to the Prometheus sink, for scrapes
+func (sks *nfsgSinks) pushAll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, sks.nme.opts.PushInterval)
+	defer cancel()
+
+	mfs, err := sks.nme.gatherer().Gather()
+	if err != nil {
+		sks.nme.log.Error(err, "failed to gather metrics")
+	} else {
+		_ = sks.prom.push(ctx, mfs)
+	}
+	wg := sync.WaitGroup{}
+	for _, sink := range sks.sinks {
+		wg.Add(1)
+		go func(sink nfsgSink) {
+			defer wg.Done()
+			if err != nil && len(mfs) == 0 {
+				sks.record(sink, err)
+				return
+			}
+			sks.pushOnce(ctx, sink, mfs)
+		}(sink)
+	}
+	wg.Wait()
+}
+
+func (sks *nfsgSinks) pushOnce(ctx context.Context, sink nfsgSink,
+	mfs []*dto.MetricFamily) {
+	err := sink.push(ctx, mfs)
+	if err != nil {
+		sks.nme.log.Error(err, "failed to push metrics", "sink", sink.sinkName())
+	}
+	sks.record(sink, err)
+}
+
+func (sks *nfsgSinks) record(sink nfsgSink, err error) {
+	sks.mu.Lock()
+	defer sks.mu.Unlock()
+	st := sks.stats[sink.sinkName()]
+	st.pushes++
+	if err != nil {
+		st.failures++
+	} else {
+		st.lastSuccess = time.Now()
+	}
+}
+
+// addDropped counts pushed data which a sink has discarded
+func (sks *nfsgSinks) addDropped(name string, n int) {
+	sks.mu.Lock()
+	defer sks.mu.Unlock()
+	sks.stats[name].dropped += uint64(n)
+}
+
+func (sks *nfsgSinks) statsOf(name string) nfsgSinkStats {
+	sks.mu.Lock()
+	defer sks.mu.Unlock()
+	return *sks.stats[name]
+}
+
+// nfsgDeltas converts cumulative counters into their increase since the
+// previous push, for sinks which expect per-interval values
+type nfsgDeltas struct {
+	prev map[string]float64
+}
+
+func newNfsgDeltas() *nfsgDeltas {
+	return &nfsgDeltas{prev: map[string]float64{}}
+}
+
+// apply replaces counters' values with their deltas. Counters seen for the
+// first time are omitted; a decreasing counter is treated as a reset
+func (dts *nfsgDeltas) apply(samples []nfsgSample) []nfsgSample {
+	next := make(map[string]float64, len(dts.prev))
+	ret := make([]nfsgSample, 0, len(samples))
+	for _, s := range samples {
+		if !s.counter {
+			ret = append(ret, s)
+			continue
+		}
+		key := s.key()
+		next[key] = s.value
+		prev, ok := dts.prev[key]
+		if !ok {
+			continue
+		}
+		if s.value >= prev {
+			s.value -= prev
+		}
+		ret = append(ret, s)
+	}
+	dts.prev = next
+	return ret
+}
+
+func (s *nfsgSample) key() string {
+	var sb strings.Builder
+	for _, l := range s.labels {
+		sb.WriteString(l.name)
+		sb.WriteByte(0xff)
+		sb.WriteString(l.value)
+		sb.WriteByte(0xff)
+	}
+	return sb.String()
+}
+
+// name returns the metric name of a sample, and its other labels
+func (s *nfsgSample) name() (string, []nfsgLabel) {
+	name := ""
+	labels := make([]nfsgLabel, 0, len(s.labels))
+	for _, l := range s.labels {
+		if l.name == "__name__" {
+			name = l.value
+		} else if l.value != "" {
+			labels = append(labels, l)
+		}
+	}
+	return name, labels
+}
+
+// writeDatagrams sends text lines over a packet connection, packing as many
+// lines into each datagram as fit within maxSize bytes
+func writeDatagrams(conn net.Conn, lines []string, maxSize int) error {
+	buf := make([]byte, 0, maxSize)
+	for _, line := range lines {
+		if len(buf) > 0 && len(buf)+len(line)+1 > maxSize {
+			if _, err := conn.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	if len(buf) > 0 {
+		if _, err := conn.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nfsgSinksCollector exports the health of push-based outputs
+type nfsgSinksCollector struct {
+	nfsgCollector
+}
+
+func (col *nfsgSinksCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, sink := range col.nme.sks.sinks {
+		name := sink.sinkName()
+		st := col.nme.sks.statsOf(name)
+		lastSuccess := float64(0)
+		if !st.lastSuccess.IsZero() {
+			lastSuccess = float64(st.lastSuccess.UnixNano()) / 1e9
+		}
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[0], prometheus.CounterValue, float64(st.pushes), name)
+
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[1], prometheus.CounterValue, float64(st.failures), name)
+
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[2], prometheus.GaugeValue, lastSuccess, name)
+
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[3], prometheus.CounterValue, float64(st.dropped), name)
+	}
+}
+
+func (nme *nfsgMetricsExporter) newNfsgSinksCollector() prometheus.Collector {
+	col := &nfsgSinksCollector{}
+	col.nme = nme
+	col.name = "sinks"
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("push", "total"),
+			"Total number of metrics pushes", []string{"sink"}, nil),
+		prometheus.NewDesc(
+			collectorName("push", "failures_total"),
+			"Total number of failed metrics pushes", []string{"sink"}, nil),
+		prometheus.NewDesc(
+			collectorName("push", "last_success_timestamp_seconds"),
+			"Time of the last successful metrics push", []string{"sink"}, nil),
+		prometheus.NewDesc(
+			collectorName("push", "dropped_total"),
+			"Total number of pushed requests dropped undelivered", []string{"sink"}, nil),
+	}
+	return col
+}
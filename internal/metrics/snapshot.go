@@ -0,0 +1,405 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var errSnapshotterClosed = errors.New("snapshotter closed")
+
+// nfsgExportEntry is a single export with its total operations stats and,
+// when enabled, its pseudo path and the clients of its access list
+type nfsgExportEntry struct {
+	export     Export
+	stats      *OperationsStats
+	pseudoPath string
+	clients    []string
+}
+
+// nfsgClientEntry is a single client with its I/O stats
+type nfsgClientEntry struct {
+	client Client
+	ios    *ClientIOs
+}
+
+// nfsgSnapshot is a point-in-time view of NFS-Ganesha exports and clients
+type nfsgSnapshot struct {
+	time        time.Time
+	exportsTime unix.Timespec
+	exports     []nfsgExportEntry
+	exportsErr  error
+	clientsTime unix.Timespec
+	clients     []nfsgClientEntry
+	clientsErr  error
+	stats       *StatsStatus
+	statsErr    error
+	owner       string
+	ownerPID    uint32
+}
+
+func (snap *nfsgSnapshot) failed() bool {
+	return snap.exportsErr != nil || snap.clientsErr != nil
+}
+
+// nfsgRefresh represents an in-flight snapshot refresh, shared by all
+// concurrent callers
+type nfsgRefresh struct {
+	done chan struct{}
+	snap *nfsgSnapshot
+}
+
+// nfsgSnapshotter fetches stats snapshots over long-lived DBus connections,
+// either on-demand or periodically in the background
+type nfsgSnapshotter struct {
+	nme           *nfsgMetricsExporter
+	mu            sync.Mutex
+	cur           *nfsgSnapshot
+	lastSuccess   time.Time
+	lastDuration  time.Duration
+	refreshes     uint64
+	failures      uint64
+	rst           *nfsgRestartTracker
+	ext           *nfsgExportTracker
+	clt           *nfsgClientTracker
+	inflight      *nfsgRefresh
+	statsOwner    string
+	noDisplay     bool
+	closed        bool
+	exportsReader *ExportsDbusReader
+	clientsReader *ClientsDbusReader
+}
+
+func newNfsgSnapshotter(nme *nfsgMetricsExporter) *nfsgSnapshotter {
+	return &nfsgSnapshotter{
+		nme: nme,
+		rst: newNfsgRestartTracker(),
+		ext: newNfsgExportTracker(nme),
+		clt: newNfsgClientTracker(nme),
+	}
+}
+
+// current returns the most recent snapshot, or nil if none exists
+func (snr *nfsgSnapshotter) current() *nfsgSnapshot {
+	snr.mu.Lock()
+	defer snr.mu.Unlock()
+	return snr.cur
+}
+
+// snapshot returns the most recent snapshot, or a fresh one if none exists
+func (snr *nfsgSnapshotter) snapshot() *nfsgSnapshot {
+	cur := snr.current()
+	if cur != nil {
+		return cur
+	}
+	return snr.refresh()
+}
+
+// fresh returns a newly fetched snapshot, unless background polling is
+// enabled in which case the most recent one is returned
+func (snr *nfsgSnapshotter) fresh() *nfsgSnapshot {
+	if snr.nme.opts.PollInterval > 0 {
+		return snr.snapshot()
+	}
+	return snr.refresh()
+}
+
+// refresh fetches a new snapshot. Concurrent callers share a single
+// in-flight fetch
+func (snr *nfsgSnapshotter) refresh() *nfsgSnapshot {
+	snr.mu.Lock()
+	if rf := snr.inflight; rf != nil {
+		snr.mu.Unlock()
+		<-rf.done
+		return rf.snap
+	}
+	rf := &nfsgRefresh{done: make(chan struct{})}
+	snr.inflight = rf
+	snr.mu.Unlock()
+
+	start := time.Now()
+	snap := snr.fetch()
+	duration := time.Since(start)
+	snr.rst.observe(snap)
+	snr.ext.observe(snap)
+	snr.clt.observe(snap)
+
+	snr.mu.Lock()
+	snr.cur = snap
+	snr.lastDuration = duration
+	snr.refreshes++
+	if snap.failed() {
+		snr.failures++
+	} else {
+		snr.lastSuccess = snap.time
+	}
+	snr.inflight = nil
+	snr.mu.Unlock()
+
+	rf.snap = snap
+	close(rf.done)
+	return snap
+}
+
+// poll refreshes snapshots periodically until the context is done
+func (snr *nfsgSnapshotter) poll(ctx context.Context) {
+	interval := snr.nme.opts.PollInterval
+	snr.nme.log.Info("start stats polling", "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	snr.refresh()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			snr.refresh()
+		}
+	}
+}
+
+// nfsgSnapshotStats holds book-keeping information on snapshot refreshes
+type nfsgSnapshotStats struct {
+	lastTime     time.Time
+	lastSuccess  time.Time
+	lastDuration time.Duration
+	refreshes    uint64
+	failures     uint64
+}
+
+func (snr *nfsgSnapshotter) stats() nfsgSnapshotStats {
+	snr.mu.Lock()
+	defer snr.mu.Unlock()
+
+	st := nfsgSnapshotStats{
+		lastSuccess:  snr.lastSuccess,
+		lastDuration: snr.lastDuration,
+		refreshes:    snr.refreshes,
+		failures:     snr.failures,
+	}
+	if snr.cur != nil {
+		st.lastTime = snr.cur.time
+	}
+	return st
+}
+
+func (snr *nfsgSnapshotter) fetch() *nfsgSnapshot {
+	snap := &nfsgSnapshot{time: time.Now()}
+	snr.fetchExports(snap)
+	snr.fetchOwner(snap)
+	snr.fetchStatsStatus(snap)
+	if snr.enableStats(snap) {
+		snr.fetchStatsStatus(snap)
+	}
+	snr.fetchClients(snap)
+	return snap
+}
+
+func (snr *nfsgSnapshotter) fetchExports(snap *nfsgSnapshot) {
+	reader, err := snr.getExportsReader()
+	if err != nil {
+		snr.nme.log.Error(err, "Collect exports stats")
+		snap.exportsErr = err
+		return
+	}
+	utime, exports, err := reader.GetExports()
+	if err != nil {
+		snr.nme.log.Error(err, "GetExports")
+		snr.closeExportsReader()
+		snap.exportsErr = err
+		return
+	}
+	snap.exportsTime = utime
+	snap.exports = make([]nfsgExportEntry, len(exports))
+	fanout(len(exports), snr.nme.opts.MaxConcurrentCalls, func(i int) {
+		export := exports[i]
+		stats, ok, err := reader.GetTotalOPS(uint16(export.ExportID))
+		if err != nil || !ok {
+			stats = nil
+		}
+		snap.exports[i] = nfsgExportEntry{
+			export: export,
+			stats:  stats,
+		}
+		if det := snr.fetchExportDetails(reader, export.ExportID); det != nil {
+			snap.exports[i].pseudoPath = det.PseudoPath
+			snap.exports[i].clients = det.Clients
+		}
+	})
+}
+
+// fetchExportDetails returns the details of an export, which are needed for
+// its access list clients or its mapping to volumes by pseudo path, or nil
+// if not needed or failed. Once NFS-Ganesha is found to lack DisplayExport,
+// it is not called again
+func (snr *nfsgSnapshotter) fetchExportDetails(
+	reader *ExportsDbusReader, exportID uint32) *ExportDetails {
+	opts := snr.nme.opts
+	if !(opts.ExportClients || opts.ResolveExportVolumes) || snr.displayMissing() {
+		return nil
+	}
+	det, err := reader.DisplayExport(uint16(exportID))
+	if isUnknownMethod(err) {
+		snr.setDisplayMissing(err)
+		return nil
+	}
+	if err != nil {
+		snr.nme.log.Error(err, "DisplayExport", "exportid", exportID)
+		return nil
+	}
+	return det
+}
+
+func (snr *nfsgSnapshotter) displayMissing() bool {
+	snr.mu.Lock()
+	defer snr.mu.Unlock()
+	return snr.noDisplay
+}
+
+func (snr *nfsgSnapshotter) setDisplayMissing(err error) {
+	snr.mu.Lock()
+	defer snr.mu.Unlock()
+	if !snr.noDisplay {
+		snr.noDisplay = true
+		snr.nme.log.Error(err, "DisplayExport not supported; export details disabled")
+	}
+}
+
+func (snr *nfsgSnapshotter) fetchClients(snap *nfsgSnapshot) {
+	reader, err := snr.getClientsReader()
+	if err != nil {
+		snr.nme.log.Error(err, "Collect clients stats")
+		snap.clientsErr = err
+		return
+	}
+	utime, clients, err := reader.GetClients()
+	if err != nil {
+		snr.nme.log.Error(err, "GetClients")
+		snr.closeClientsReader()
+		snap.clientsErr = err
+		return
+	}
+	snap.clientsTime = utime
+	snap.clients = make([]nfsgClientEntry, len(clients))
+	fanout(len(clients), snr.nme.opts.MaxConcurrentCalls, func(i int) {
+		client := clients[i]
+		ios, ok, err := reader.GetClientIOs(client.Client)
+		if err != nil || !ok {
+			ios = nil
+		}
+		snap.clients[i] = nfsgClientEntry{
+			client: client,
+			ios:    ios,
+		}
+	})
+}
+
+// fanout calls fn for each index in [0, n) using at most par concurrent
+// workers, and waits for all calls to complete. Callers are expected to
+// store results by index, so that output order is deterministic
+func fanout(n, par int, fn func(i int)) {
+	if par > n {
+		par = n
+	}
+	if par <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	idx := make(chan int)
+	wg := sync.WaitGroup{}
+	wg.Add(par)
+	for w := 0; w < par; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+}
+
+// close waits for any in-flight refresh and closes all DBus connections
+func (snr *nfsgSnapshotter) close() {
+	snr.mu.Lock()
+	snr.closed = true
+	rf := snr.inflight
+	snr.mu.Unlock()
+	if rf != nil {
+		<-rf.done
+	}
+
+	snr.mu.Lock()
+	defer snr.mu.Unlock()
+	snr.closeExportsReader()
+	snr.closeClientsReader()
+}
+
+func (snr *nfsgSnapshotter) isClosed() bool {
+	snr.mu.Lock()
+	defer snr.mu.Unlock()
+	return snr.closed
+}
+
+func (snr *nfsgSnapshotter) getExportsReader() (*ExportsDbusReader, error) {
+	if snr.isClosed() {
+		return nil, errSnapshotterClosed
+	}
+	if snr.exportsReader != nil {
+		return snr.exportsReader, nil
+	}
+	reader := NewExportsDbusReader()
+	if snr.nme.opts.DebugEndpoints {
+		reader.SetTrace(snr.nme.dbt.record)
+	}
+	if err := reader.Setup(); err != nil {
+		return nil, err
+	}
+	snr.exportsReader = reader
+	return reader, nil
+}
+
+func (snr *nfsgSnapshotter) getClientsReader() (*ClientsDbusReader, error) {
+	if snr.isClosed() {
+		return nil, errSnapshotterClosed
+	}
+	if snr.clientsReader != nil {
+		return snr.clientsReader, nil
+	}
+	reader := NewClientsDbusReader()
+	if snr.nme.opts.DebugEndpoints {
+		reader.SetTrace(snr.nme.dbt.record)
+	}
+	if err := reader.Setup(); err != nil {
+		return nil, err
+	}
+	snr.clientsReader = reader
+	return reader, nil
+}
+
+func (snr *nfsgSnapshotter) closeExportsReader() {
+	if snr.exportsReader != nil {
+		snr.exportsReader.Close()
+		snr.exportsReader = nil
+	}
+}
+
+func (snr *nfsgSnapshotter) closeClientsReader() {
+	if snr.clientsReader != nil {
+		snr.clientsReader.Close()
+		snr.clientsReader = nil
+	}
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	statsdMaxDatagram = 1432
+)
+
+// nfsgStatsdSink pushes all gathered metrics to a DogStatsD agent over UDP:
+// counters as per-interval deltas and gauges as is, with labels as tags
+type nfsgStatsdSink struct {
+	nme  *nfsgMetricsExporter
+	conn net.Conn
+	dts  *nfsgDeltas
+	tags []string
+}
+
+func newNfsgStatsdSink(nme *nfsgMetricsExporter) (*nfsgStatsdSink, error) {
+	conn, err := net.Dial("udp", nme.opts.StatsdAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &nfsgStatsdSink{
+		nme:  nme,
+		conn: conn,
+		dts:  newNfsgDeltas(),
+		tags: nme.opts.StatsdTags,
+	}, nil
+}
+
+func (sds *nfsgStatsdSink) sinkName() string {
+	return "statsd"
+}
+
+func (sds *nfsgStatsdSink) push(_ context.Context, mfs []*dto.MetricFamily) error {
+	samples := sds.dts.apply(flattenMetrics(mfs))
+	lines := make([]string, 0, len(samples))
+	for i := range samples {
+		lines = append(lines, sds.format(&samples[i]))
+	}
+	return writeDatagrams(sds.conn, lines, statsdMaxDatagram)
+}
+
+// format returns a DogStatsD line: "name:value|type|#tag:value,..."
+func (sds *nfsgStatsdSink) format(s *nfsgSample) string {
+	name, labels := s.name()
+	typ := "g"
+	if s.counter {
+		typ = "c"
+	}
+	var sb strings.Builder
+	sb.WriteString(name)
+	sb.WriteByte(':')
+	sb.WriteString(formatFloat(s.value))
+	sb.WriteByte('|')
+	sb.WriteString(typ)
+	tags := append([]string{}, sds.tags...)
+	for _, l := range labels {
+		tags = append(tags, l.name+":"+escapeStatsdTag(l.value))
+	}
+	if len(tags) > 0 {
+		sb.WriteString("|#")
+		sb.WriteString(strings.Join(tags, ","))
+	}
+	return sb.String()
+}
+
+func escapeStatsdTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func (sds *nfsgStatsdSink) close() {
+	sds.conn.Close()
+}
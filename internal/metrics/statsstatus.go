@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// StatsTypeAll denotes all types of NFS-Ganesha stats
+	StatsTypeAll = "all"
+)
+
+// checkStatsTypes verifies that all given stats types are known
+func checkStatsTypes(types []string) error {
+	for _, typ := range types {
+		if typ != StatsTypeAll && !isStatsType(typ) {
+			return fmt.Errorf("unknown stats type: %s", typ)
+		}
+	}
+	return nil
+}
+
+func isStatsType(typ string) bool {
+	for _, st := range StatsTypes {
+		if st == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchStatsStatus queries which stats types are enabled. Failure does not
+// fail the snapshot, as older NFS-Ganesha versions lack StatusStats
+func (snr *nfsgSnapshotter) fetchStatsStatus(snap *nfsgSnapshot) {
+	if snap.exportsErr != nil {
+		snap.statsErr = snap.exportsErr
+		return
+	}
+	reader, err := snr.getExportsReader()
+	if err != nil {
+		snap.statsErr = err
+		return
+	}
+	snap.stats, snap.statsErr = getStatsStatus(reader)
+	if snap.statsErr != nil {
+		snr.nme.log.Error(snap.statsErr, "StatusStats")
+	}
+}
+
+func getStatsStatus(reader *ExportsDbusReader) (*StatsStatus, error) {
+	status, ok, err := reader.GetStatsStatus()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New(status.Error)
+	}
+	return status, nil
+}
+
+// enableStats enables the stats types requested by options which a stats
+// status reports as disabled. It is done once per NFS-Ganesha instance, as
+// identified by its bus name owner: a restarted NFS-Ganesha starts with its
+// stats disabled again. Later snapshots of the same instance retry only
+// until all EnableStats calls succeed. Returns true if any was called, in
+// which case the stats status is stale
+func (snr *nfsgSnapshotter) enableStats(snap *nfsgSnapshot) bool {
+	if snap.stats == nil || snap.owner == "" || snap.owner == snr.statsOwner {
+		return false
+	}
+	reader, err := snr.getExportsReader()
+	if err != nil {
+		return false
+	}
+	called := false
+	failed := false
+	for _, typ := range snr.nme.opts.EnableStats {
+		if !statsDisabled(snap.stats, typ) {
+			continue
+		}
+		called = true
+		reply, ok, err := reader.EnableStats(typ)
+		if err == nil && !ok {
+			err = errors.New(reply.Error)
+		}
+		if err != nil {
+			snr.nme.log.Error(err, "EnableStats", "type", typ)
+			failed = true
+		} else {
+			snr.nme.log.Info("enabled stats", "type", typ)
+		}
+	}
+	if !failed {
+		snr.statsOwner = snap.owner
+	}
+	return called
+}
+
+// statsDisabled returns true if the given stats type, or any type in case
+// of "all", is reported as disabled
+func statsDisabled(status *StatsStatus, typ string) bool {
+	for _, st := range status.Stats {
+		if (typ == StatsTypeAll || st.Type == typ) && !st.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// nfsgStatsStatusCollector exports which types of NFS-Ganesha stats are
+// enabled, as disabled stats yield all-zero series
+type nfsgStatsStatusCollector struct {
+	nfsgCollector
+}
+
+func (col *nfsgStatsStatusCollector) Collect(ch chan<- prometheus.Metric) {
+	snap := col.nme.snr.snapshot()
+	if snap.stats == nil {
+		return
+	}
+	for _, st := range snap.stats.Stats {
+		enabled := float64(0)
+		if st.Enabled {
+			enabled = 1
+		}
+		ch <- prometheus.MustNewConstMetric(
+			col.dsc[0], prometheus.GaugeValue, enabled, st.Type)
+
+		if st.Enabled {
+			since := float64(st.Since.Sec) + float64(st.Since.Nsec)/1e9
+			ch <- prometheus.MustNewConstMetric(
+				col.dsc[1], prometheus.GaugeValue, since, st.Type)
+		}
+	}
+}
+
+func (nme *nfsgMetricsExporter) newNfsgStatsStatusCollector() prometheus.Collector {
+	col := &nfsgStatsStatusCollector{}
+	col.nme = nme
+	col.name = "stats_status"
+	col.dsc = []*prometheus.Desc{
+		prometheus.NewDesc(
+			collectorName("stats", "enabled"),
+			"Whether NFS-Ganesha stats of this type are enabled",
+			[]string{"type"}, nil),
+		prometheus.NewDesc(
+			collectorName("stats", "enabled_since_timestamp_seconds"),
+			"Time since which NFS-Ganesha stats of this type are enabled",
+			[]string{"type"}, nil),
+	}
+	return col
+}
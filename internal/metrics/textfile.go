@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/go-logr/logr"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var (
+	// DefaultTextfileName is the default name of metrics file within the
+	// textfile directory
+	DefaultTextfileName = "nfs_ganesha.prom"
+)
+
+// nfsgTextfileSink writes all gathered metrics into a file of
+// node_exporter's textfile collector. The file is replaced atomically so
+// that node_exporter never reads partial content
+type nfsgTextfileSink struct {
+	nme  *nfsgMetricsExporter
+	path string
+}
+
+func newNfsgTextfileSink(nme *nfsgMetricsExporter) *nfsgTextfileSink {
+	return &nfsgTextfileSink{
+		nme:  nme,
+		path: filepath.Join(nme.opts.TextfileDir, nme.opts.TextfileName),
+	}
+}
+
+func (tfs *nfsgTextfileSink) sinkName() string {
+	return "textfile"
+}
+
+func (tfs *nfsgTextfileSink) push(_ context.Context, mfs []*dto.MetricFamily) error {
+	tmp, err := os.CreateTemp(filepath.Dir(tfs.path), "."+filepath.Base(tfs.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	for _, mf := range mfs {
+		if _, err = expfmt.MetricFamilyToText(tmp, mf); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err = tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), tfs.path)
+}
+
+func (tfs *nfsgTextfileSink) close() {
+}
+
+// WriteNfsgMetricsTextfile gathers NFS-Ganesha stats once and writes them
+// into the textfile directory, for periodic execution by cron. It fails if
+// NFS-Ganesha stats could not be fetched, leaving any previous file intact.
+func WriteNfsgMetricsTextfile(ctx context.Context, log logr.Logger, opts *Options) error {
+	if opts.TextfileDir == "" {
+		err := errors.New("textfile directory required")
+		log.Error(err, "failed to write metrics textfile")
+		return err
+	}
+	nme := newNfsgMetricsExporter(log, opts)
+	err := nme.init()
+	if err != nil {
+		return err
+	}
+	defer nme.close()
+
+	tfs := newNfsgTextfileSink(nme)
+	snap := nme.snr.refresh()
+	for _, err = range []error{snap.exportsErr, snap.clientsErr} {
+		if err != nil {
+			nme.log.Error(err, "failed to fetch stats; metrics not written",
+				"path", tfs.path)
+			return err
+		}
+	}
+	mfs, err := nme.reg.Gather()
+	if err == nil {
+		err = tfs.push(ctx, mfs)
+	}
+	if err != nil {
+		nme.log.Error(err, "failed to write metrics", "path", tfs.path)
+		return err
+	}
+	nme.log.Info("metrics written", "path", tfs.path)
+	return nil
+}
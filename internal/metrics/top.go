@@ -0,0 +1,431 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/term"
+)
+
+var (
+	// DefaultTopInterval is the default refresh period of live terminal view
+	DefaultTopInterval = time.Second
+)
+
+// TopOptions defines the initial state of live terminal view
+type TopOptions struct {
+	// Interval is the period of stats polling and screen refresh
+	Interval time.Duration
+	// Exports starts with exports view instead of clients view
+	Exports bool
+	// CIDR limits clients view to clients within this network
+	CIDR string
+	// Path limits exports view to exports whose path has this prefix
+	Path string
+}
+
+var (
+	topClientColumns = []string{
+		"OPS/s", "RBYTES/s", "WBYTES/s", "READ/s", "WRITE/s", "ERR/s",
+	}
+	topExportColumns = []string{
+		"OPS/s", "NFSv3/s", "NFSv40/s", "NFSv41/s", "NFSv42/s", "NLM/s", "MNT/s",
+	}
+	topBytesColumns = map[string]bool{"RBYTES/s": true, "WBYTES/s": true}
+)
+
+// nfsgTopRow is a single line of live terminal view
+type nfsgTopRow struct {
+	name   string
+	protos string
+	rates  []float64
+}
+
+// nfsgTop is a top-like live terminal view of per-client and per-export
+// rates, computed from consecutive stats snapshots
+type nfsgTop struct {
+	nme     *nfsgMetricsExporter
+	topts   TopOptions
+	prev    *nfsgSnapshot
+	cur     *nfsgSnapshot
+	sortCol int
+	cidr    *net.IPNet
+	editing bool
+	input   string
+	status  string
+	failure string
+	out     *bufio.Writer
+}
+
+// RunNfsgTop runs a live terminal view of NFS-Ganesha per-client and
+// per-export rates, until the context is done or the user quits.
+func RunNfsgTop(ctx context.Context, log logr.Logger, opts *Options, topts TopOptions) error {
+	if topts.Interval <= 0 {
+		topts.Interval = DefaultTopInterval
+	}
+	nme := newNfsgMetricsExporter(log, opts)
+	defer nme.close()
+
+	top := &nfsgTop{
+		nme:     nme,
+		topts:   topts,
+		sortCol: 1,
+		out:     bufio.NewWriter(os.Stdout),
+	}
+	if topts.CIDR != "" {
+		if err := top.setCIDR(topts.CIDR); err != nil {
+			return err
+		}
+	}
+
+	fd := int(os.Stdin.Fd())
+	var keys chan byte
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = term.Restore(fd, state) }()
+		keys = make(chan byte)
+		go readKeys(keys)
+	}
+	fmt.Fprint(os.Stdout, "\x1b[?25l")
+	defer fmt.Fprint(os.Stdout, "\x1b[?25h\r\n")
+
+	ticker := time.NewTicker(topts.Interval)
+	defer ticker.Stop()
+	top.poll()
+	for {
+		top.render()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			top.poll()
+		case key, ok := <-keys:
+			if !ok {
+				keys = nil
+			} else if !top.handleKey(key) {
+				return nil
+			}
+		}
+	}
+}
+
+func readKeys(keys chan<- byte) {
+	buf := make([]byte, 1)
+	for {
+		if _, err := os.Stdin.Read(buf); err != nil {
+			close(keys)
+			return
+		}
+		keys <- buf[0]
+	}
+}
+
+func (top *nfsgTop) poll() {
+	snap := top.nme.snr.refresh()
+	if snap.failed() {
+		for _, err := range []error{snap.exportsErr, snap.clientsErr} {
+			if err != nil {
+				top.failure = err.Error()
+			}
+		}
+		return
+	}
+	top.failure = ""
+	top.prev = top.cur
+	top.cur = snap
+}
+
+// handleKey applies a single key press and returns false upon quit
+func (top *nfsgTop) handleKey(key byte) bool {
+	if top.editing {
+		top.editKey(key)
+		return true
+	}
+	switch key {
+	case 'q', 3:
+		return false
+	case '\t', 'v':
+		top.topts.Exports = !top.topts.Exports
+		top.sortCol = 1
+	case 's':
+		top.sortCol = (top.sortCol + 1) % (len(top.columns()) + 1)
+	case '/':
+		top.editing = true
+		top.input = ""
+	case 'c':
+		top.cidr = nil
+		top.topts.Path = ""
+		top.status = ""
+	}
+	return true
+}
+
+func (top *nfsgTop) editKey(key byte) {
+	switch key {
+	case '\r', '\n':
+		top.editing = false
+		top.status = ""
+		if !top.topts.Exports {
+			if err := top.setCIDR(top.input); err != nil {
+				top.status = err.Error()
+			}
+		} else {
+			top.topts.Path = top.input
+		}
+	case 27, 3:
+		top.editing = false
+	case 127, 8:
+		if len(top.input) > 0 {
+			top.input = top.input[:len(top.input)-1]
+		}
+	default:
+		if key >= ' ' && key < 127 {
+			top.input += string(key)
+		}
+	}
+}
+
+func (top *nfsgTop) setCIDR(cidr string) error {
+	if cidr == "" {
+		top.cidr = nil
+		return nil
+	}
+	nets, err := parseCIDRs([]string{cidr})
+	if err != nil {
+		return err
+	}
+	top.cidr = nets[0]
+	return nil
+}
+
+func (top *nfsgTop) columns() []string {
+	if top.topts.Exports {
+		return topExportColumns
+	}
+	return topClientColumns
+}
+
+// rows returns the rates of current view since previous snapshot, filtered
+// and sorted by the selected column; column zero sorts by name, others by
+// descending rate
+func (top *nfsgTop) rows() []nfsgTopRow {
+	if top.cur == nil || top.prev == nil {
+		return nil
+	}
+	secs := top.cur.time.Sub(top.prev.time).Seconds()
+	if secs <= 0 {
+		return nil
+	}
+	var rows []nfsgTopRow
+	if top.topts.Exports {
+		rows = top.exportRows(secs)
+	} else {
+		rows = top.clientRows(secs)
+	}
+	col := top.sortCol - 1
+	sort.SliceStable(rows, func(i, j int) bool {
+		if col < 0 {
+			return rows[i].name < rows[j].name
+		}
+		return rows[i].rates[col] > rows[j].rates[col]
+	})
+	return rows
+}
+
+func (top *nfsgTop) clientRows(secs float64) []nfsgTopRow {
+	prev := map[string]*ClientIOs{}
+	for _, ent := range top.prev.clients {
+		prev[ent.client.Client] = ent.ios
+	}
+	rows := []nfsgTopRow{}
+	for _, ent := range top.cur.clients {
+		ip := net.ParseIP(ent.client.Client)
+		if top.cidr != nil && (ip == nil || !top.cidr.Contains(ip)) {
+			continue
+		}
+		cur := IOStats{}
+		if ent.ios != nil {
+			cur = sumIOStats(&ent.ios.ClientIOStats)
+		}
+		old := cur
+		if ios := prev[ent.client.Client]; ios != nil {
+			old = sumIOStats(&ios.ClientIOStats)
+		}
+		rate := func(c, o uint64) float64 {
+			return rateOf(c, o, secs)
+		}
+		rows = append(rows, nfsgTopRow{
+			name:   ent.client.Client,
+			protos: clientProtocols(&ent.client),
+			rates: []float64{
+				rate(ioOps(&cur), ioOps(&old)),
+				rate(cur.Read.Transferred, old.Read.Transferred),
+				rate(cur.Write.Transferred, old.Write.Transferred),
+				rate(cur.Read.Total, old.Read.Total),
+				rate(cur.Write.Total, old.Write.Total),
+				rate(ioErrors(&cur), ioErrors(&old)),
+			},
+		})
+	}
+	return rows
+}
+
+// rateOf returns the per-second rate of a counter; a decreasing counter
+// is treated as a reset
+func rateOf(cur, old uint64, secs float64) float64 {
+	if cur < old {
+		return 0
+	}
+	return float64(cur-old) / secs
+}
+
+func ioOps(st *IOStats) uint64 {
+	return st.Read.Total + st.Write.Total + st.Other.Total + st.Layout.Total
+}
+
+func (top *nfsgTop) exportRows(secs float64) []nfsgTopRow {
+	prev := map[uint32]*OperationsStats{}
+	for _, ent := range top.prev.exports {
+		prev[ent.export.ExportID] = ent.stats
+	}
+	rows := []nfsgTopRow{}
+	for _, ent := range top.cur.exports {
+		if !strings.HasPrefix(ent.export.Path, top.topts.Path) {
+			continue
+		}
+		cur := OperationCount{}
+		if ent.stats != nil {
+			cur = ent.stats.OPS
+		}
+		old := cur
+		if st := prev[ent.export.ExportID]; st != nil {
+			old = st.OPS
+		}
+		rate := func(c, o uint64) float64 {
+			return rateOf(c, o, secs)
+		}
+		rows = append(rows, nfsgTopRow{
+			name:   fmt.Sprintf("%d:%s", ent.export.ExportID, ent.export.Path),
+			protos: exportProtocols(&ent.export),
+			rates: []float64{
+				rate(totalOps(&cur), totalOps(&old)),
+				rate(cur.NFSv3, old.NFSv3),
+				rate(cur.NFSv40, old.NFSv40),
+				rate(cur.NFSv41, old.NFSv41),
+				rate(cur.NFSv42, old.NFSv42),
+				rate(cur.NLMv4, old.NLMv4),
+				rate(cur.MNTv1+cur.MNTv3, old.MNTv1+old.MNTv3),
+			},
+		})
+	}
+	return rows
+}
+
+func totalOps(ops *OperationCount) uint64 {
+	return ops.NFSv3 + ops.MNTv1 + ops.MNTv3 + ops.NLMv4 + ops.RQUOTA +
+		ops.NFSv40 + ops.NFSv41 + ops.NFSv42 + ops.Plan9
+}
+
+func (top *nfsgTop) render() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 120, 40
+	}
+	lines := top.header()
+	cols := top.columns()
+	head := fmt.Sprintf("%-40s %-24s", "EXPORT", "PROTOCOLS")
+	if !top.topts.Exports {
+		head = fmt.Sprintf("%-40s %-24s", "CLIENT", "PROTOCOLS")
+	}
+	for _, col := range cols {
+		head += fmt.Sprintf(" %10s", col)
+	}
+	for i := range lines {
+		lines[i] = truncate(lines[i], width)
+	}
+	lines = append(lines, "", "\x1b[7m"+truncate(padRight(head, width), width)+"\x1b[0m")
+	for _, row := range top.rows() {
+		line := fmt.Sprintf("%-40s %-24s", truncate(row.name, 40), truncate(row.protos, 24))
+		for i, v := range row.rates {
+			line += fmt.Sprintf(" %10s", formatRate(v, topBytesColumns[cols[i]]))
+		}
+		lines = append(lines, truncate(line, width))
+	}
+	if len(lines) > height-1 {
+		lines = lines[:height-1]
+	}
+	top.out.WriteString("\x1b[H\x1b[2J")
+	for _, line := range lines {
+		top.out.WriteString(line)
+		top.out.WriteString("\r\n")
+	}
+	_ = top.out.Flush()
+}
+
+func (top *nfsgTop) header() []string {
+	view, filter := "clients", "all"
+	if top.cidr != nil {
+		filter = top.cidr.String()
+	}
+	if top.topts.Exports {
+		view, filter = "exports", "all"
+		if top.topts.Path != "" {
+			filter = top.topts.Path + "*"
+		}
+	}
+	sortBy := "name"
+	if top.sortCol > 0 {
+		sortBy = top.columns()[top.sortCol-1]
+	}
+	hdr := fmt.Sprintf("nfsgmetrics top - %s  view: %s  sort: %s  filter: %s",
+		time.Now().Format("15:04:05"), view, sortBy, filter)
+	if top.editing {
+		return []string{hdr, "filter> " + top.input}
+	}
+	if top.failure != "" {
+		return []string{hdr, top.failure}
+	}
+	if top.status != "" {
+		return []string{hdr, top.status}
+	}
+	return []string{hdr, "[tab] view  [s] sort  [/] filter  [c] clear  [q] quit"}
+}
+
+func formatRate(v float64, bytes bool) string {
+	if !bytes {
+		return fmt.Sprintf("%.1f", v)
+	}
+	units := []string{"B", "K", "M", "G", "T"}
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%s", v, units[i])
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+func padRight(s string, n int) string {
+	if len(s) < n {
+		return s + strings.Repeat(" ", n-len(s))
+	}
+	return s
+}
@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// serverLookupTTL is the period to cache addresses of server names
+	serverLookupTTL = 5 * time.Minute
+)
+
+// nfsgVolumeRef identifies a Kubernetes PersistentVolume and its bound claim
+type nfsgVolumeRef struct {
+	pv           string
+	pvc          string
+	namespace    string
+	storageClass string
+}
+
+// nfsgServerEntry is a cached result of a server name lookup
+type nfsgServerEntry struct {
+	addrs   []string
+	expires time.Time
+	pending bool
+}
+
+// nfsgVolumeResolver maps NFS-Ganesha exports to Kubernetes PersistentVolumes
+// (NFS and CSI volumes) whose server and path refer to this NFS-Ganesha.
+// Server names are resolved in the background, so that scrapes never block
+// on DNS
+type nfsgVolumeResolver struct {
+	nme     *nfsgMetricsExporter
+	ctx     context.Context
+	pvs     cache.Store
+	servers map[string]bool
+	mu      sync.Mutex
+	names   map[string]*nfsgServerEntry
+}
+
+func newNfsgVolumeResolver(nme *nfsgMetricsExporter) *nfsgVolumeResolver {
+	servers := map[string]bool{}
+	for _, s := range nme.opts.ExportServers {
+		servers[s] = true
+	}
+	return &nfsgVolumeResolver{
+		nme:     nme,
+		ctx:     context.Background(),
+		servers: servers,
+		names:   map[string]*nfsgServerEntry{},
+	}
+}
+
+func (vr *nfsgVolumeResolver) enabled() bool {
+	return vr.nme.opts.ResolveExportVolumes
+}
+
+// start launches the persistent-volumes informer, if enabled
+func (vr *nfsgVolumeResolver) start(ctx context.Context) {
+	if !vr.enabled() {
+		return
+	}
+	vr.ctx = ctx
+	clnt, err := vr.nme.getKClient()
+	if err != nil {
+		vr.nme.log.Error(err, "failed to create kubernetes client")
+		return
+	}
+	if len(vr.servers) == 0 {
+		servers, err := selfServers(ctx, clnt)
+		if err != nil {
+			vr.nme.log.Error(err, "failed to resolve export servers; "+
+				"set export-servers to map exports to volumes")
+			return
+		}
+		vr.nme.log.Info("export servers of self pod and node", "servers", servers)
+		for _, server := range servers {
+			vr.servers[server] = true
+		}
+	}
+	factory := informers.NewSharedInformerFactory(clnt.ClientSet, 0)
+	informer := factory.Core().V1().PersistentVolumes().Informer()
+	vr.nme.log.Info("start persistent-volumes informer")
+	factory.Start(ctx.Done())
+	vr.pvs = informer.GetStore()
+}
+
+// selfServers returns the addresses of the exporter's own pod and node, which
+// are the default NFS server addresses of this NFS-Ganesha
+func selfServers(ctx context.Context, clnt *kclient) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	pod, err := GetSelfPod(ctx, clnt)
+	if err != nil {
+		return nil, err
+	}
+	servers := []string{}
+	for _, ip := range pod.Status.PodIPs {
+		servers = append(servers, ip.IP)
+	}
+	if pod.Status.HostIP != "" {
+		servers = append(servers, pod.Status.HostIP)
+	}
+	node, err := getPodNode(ctx, clnt, pod)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range node.Status.Addresses {
+		servers = append(servers, addr.Address)
+	}
+	return servers, nil
+}
+
+// volumeSource returns the NFS server and path of a persistent volume, for
+// both in-tree NFS volumes and CSI volumes with server/share attributes
+func volumeSource(pv *corev1.PersistentVolume) (string, string, bool) {
+	if nfs := pv.Spec.NFS; nfs != nil {
+		return nfs.Server, normalizeExportPath(nfs.Path), true
+	}
+	if csi := pv.Spec.CSI; csi != nil {
+		attrs := csi.VolumeAttributes
+		server, share := attrs["server"], attrs["share"]
+		if server == "" || share == "" {
+			return "", "", false
+		}
+		if subDir := attrs["subDir"]; subDir != "" {
+			share = path.Join(share, subDir)
+		}
+		return server, normalizeExportPath(share), true
+	}
+	return "", "", false
+}
+
+func normalizeExportPath(p string) string {
+	if p == "" {
+		return p
+	}
+	return path.Clean("/" + strings.TrimSpace(p))
+}
+
+// resolve maps each export to the persistent volumes which refer to it. A
+// volume refers to the export whose path or pseudo path is the longest
+// prefix of the volume's path, as volumes may mount a sub-directory
+func (vr *nfsgVolumeResolver) resolve(exports []nfsgExportEntry) map[uint32][]nfsgVolumeRef {
+	refs := map[uint32][]nfsgVolumeRef{}
+	if vr.pvs == nil {
+		return refs
+	}
+	for _, obj := range vr.pvs.List() {
+		pv, ok := obj.(*corev1.PersistentVolume)
+		if !ok {
+			continue
+		}
+		server, vpath, ok := volumeSource(pv)
+		if !ok || !vr.isServer(server) {
+			continue
+		}
+		exportID, ok := longestExportPrefix(exports, vpath)
+		if !ok {
+			continue
+		}
+		ref := nfsgVolumeRef{
+			pv:           pv.Name,
+			storageClass: pv.Spec.StorageClassName,
+		}
+		if claim := pv.Spec.ClaimRef; claim != nil {
+			ref.pvc = claim.Name
+			ref.namespace = claim.Namespace
+		}
+		refs[exportID] = append(refs[exportID], ref)
+	}
+	return refs
+}
+
+// longestExportPrefix returns the id of the export whose path or pseudo path
+// is the longest prefix of vpath
+func longestExportPrefix(exports []nfsgExportEntry, vpath string) (uint32, bool) {
+	exportID := uint32(0)
+	best := -1
+	for _, ent := range exports {
+		for _, epath := range []string{ent.export.Path, ent.pseudoPath} {
+			epath = normalizeExportPath(epath)
+			if len(epath) > best && isPathPrefix(epath, vpath) {
+				exportID = ent.export.ExportID
+				best = len(epath)
+			}
+		}
+	}
+	return exportID, best >= 0
+}
+
+func isPathPrefix(prefix, p string) bool {
+	if prefix == "" {
+		return false
+	}
+	return prefix == p || prefix == "/" || strings.HasPrefix(p, prefix+"/")
+}
+
+// isServer returns true if a volume's server is one of this NFS-Ganesha's
+// addresses, either literally or by its resolved addresses
+func (vr *nfsgVolumeResolver) isServer(server string) bool {
+	if vr.servers[server] {
+		return true
+	}
+	if net.ParseIP(server) != nil {
+		return false
+	}
+	for _, addr := range vr.lookupServer(server) {
+		if vr.servers[addr] {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupServer returns the cached addresses of a server name, and starts a
+// background lookup if missing or expired
+func (vr *nfsgVolumeResolver) lookupServer(name string) []string {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+
+	ent, ok := vr.names[name]
+	if ok && (ent.pending || time.Now().Before(ent.expires)) {
+		return ent.addrs
+	}
+	if !ok {
+		ent = &nfsgServerEntry{}
+		vr.names[name] = ent
+	}
+	ent.pending = true
+	go vr.resolveServer(name, ent)
+	return ent.addrs
+}
+
+func (vr *nfsgVolumeResolver) resolveServer(name string, ent *nfsgServerEntry) {
+	ctx, cancel := context.WithTimeout(vr.ctx, 5*time.Second)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+	if err != nil {
+		vr.nme.log.Error(err, "failed to resolve volume server", "server", name)
+	}
+
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	ent.addrs = addrs
+	ent.expires = time.Now().Add(serverLookupTTL)
+	ent.pending = false
+}
@@ -0,0 +1,432 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// webConfigCheckInterval is the minimal period between checks for
+	// changes of the web-config file and its referenced files
+	webConfigCheckInterval = 3 * time.Second
+)
+
+// nfsgTLSConfig is the TLS section of web-config file, compatible with the
+// Prometheus exporter-toolkit format
+type nfsgTLSConfig struct {
+	CertFile                 string   `yaml:"cert_file"`
+	KeyFile                  string   `yaml:"key_file"`
+	ClientAuthType           string   `yaml:"client_auth_type"`
+	ClientCAFile             string   `yaml:"client_ca_file"`
+	MinVersion               string   `yaml:"min_version"`
+	MaxVersion               string   `yaml:"max_version"`
+	CipherSuites             []string `yaml:"cipher_suites"`
+	CurvePreferences         []string `yaml:"curve_preferences"`
+	PreferServerCipherSuites bool     `yaml:"prefer_server_cipher_suites"`
+}
+
+// nfsgHTTPConfig is the HTTP section of web-config file
+type nfsgHTTPConfig struct {
+	HTTP2   bool              `yaml:"http2"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// nfsgWebConfig is the content of web-config file, compatible with the
+// Prometheus exporter-toolkit format
+type nfsgWebConfig struct {
+	TLSConfig      *nfsgTLSConfig    `yaml:"tls_server_config"`
+	HTTPConfig     nfsgHTTPConfig    `yaml:"http_server_config"`
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+
+	// unknown reports keys which are not supported, if any
+	unknown error
+}
+
+func (wc *nfsgWebConfig) hasTLS() bool {
+	return wc.TLSConfig != nil && wc.TLSConfig.CertFile != ""
+}
+
+// nfsgWebGuard applies web-config settings to the exporter's HTTP server:
+// TLS with optional client verification and bcrypt basic authentication.
+// The config file and any referenced certificate files are reloaded upon
+// change, except for enabling or disabling TLS or HTTP/2 which apply to
+// listeners upon startup only
+type nfsgWebGuard struct {
+	nme       *nfsgMetricsExporter
+	path      string
+	mu        sync.Mutex
+	cfg       *nfsgWebConfig
+	tlsCfg    *tls.Config
+	mtimes    map[string]time.Time
+	checked   time.Time
+	authCache map[[sha256.Size]byte]bool
+}
+
+func newNfsgWebGuard(nme *nfsgMetricsExporter) (*nfsgWebGuard, error) {
+	wg := &nfsgWebGuard{
+		nme:  nme,
+		path: nme.opts.WebConfigFile,
+	}
+	if wg.path == "" {
+		wg.cfg = newWebConfig()
+		return wg, nil
+	}
+	if err := wg.reload(); err != nil {
+		return nil, err
+	}
+	return wg, nil
+}
+
+func newWebConfig() *nfsgWebConfig {
+	return &nfsgWebConfig{HTTPConfig: nfsgHTTPConfig{HTTP2: true}}
+}
+
+// loadWebConfig parses a web-config file. Unknown keys are tolerated, for
+// compatibility with newer exporter-toolkit versions
+func loadWebConfig(path string) (*nfsgWebConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := newWebConfig()
+	if err = yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	cfg.unknown = yaml.UnmarshalStrict(data, newWebConfig())
+	if tc := cfg.TLSConfig; tc != nil {
+		dir := filepath.Dir(path)
+		tc.CertFile = joinConfigPath(dir, tc.CertFile)
+		tc.KeyFile = joinConfigPath(dir, tc.KeyFile)
+		tc.ClientCAFile = joinConfigPath(dir, tc.ClientCAFile)
+		if (tc.CertFile == "") != (tc.KeyFile == "") {
+			return nil, errors.New("both cert_file and key_file required")
+		}
+	}
+	return cfg, nil
+}
+
+func joinConfigPath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// changed returns true if any of the config file or its referenced files
+// were modified since last load
+func (wg *nfsgWebGuard) changed() bool {
+	for path, mtime := range wg.mtimes {
+		st, err := os.Stat(path)
+		if err != nil || !st.ModTime().Equal(mtime) {
+			return true
+		}
+	}
+	return false
+}
+
+func (wg *nfsgWebGuard) watchedFiles(cfg *nfsgWebConfig) []string {
+	files := []string{wg.path}
+	if tc := cfg.TLSConfig; tc != nil {
+		files = append(files, tc.CertFile, tc.KeyFile, tc.ClientCAFile)
+	}
+	return files
+}
+
+// reload re-reads the web-config file and re-creates its TLS config
+func (wg *nfsgWebGuard) reload() error {
+	cfg, err := loadWebConfig(wg.path)
+	if err != nil {
+		return err
+	}
+	if prev := wg.cfg; prev != nil {
+		if cfg.hasTLS() != prev.hasTLS() {
+			return errors.New("enabling or disabling TLS requires restart")
+		}
+		if cfg.HTTPConfig.HTTP2 != prev.HTTPConfig.HTTP2 {
+			return errors.New("enabling or disabling HTTP/2 requires restart")
+		}
+	}
+	if cfg.unknown != nil {
+		wg.nme.log.Info("unsupported web config keys ignored",
+			"path", wg.path, "details", cfg.unknown.Error())
+	}
+	var tlsCfg *tls.Config
+	if cfg.hasTLS() {
+		tlsCfg, err = newTLSConfig(cfg.TLSConfig, cfg.HTTPConfig.HTTP2)
+		if err != nil {
+			return err
+		}
+	}
+	mtimes := map[string]time.Time{}
+	for _, path := range wg.watchedFiles(cfg) {
+		if path == "" {
+			continue
+		}
+		st, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		mtimes[path] = st.ModTime()
+	}
+	wg.cfg = cfg
+	wg.tlsCfg = tlsCfg
+	wg.mtimes = mtimes
+	wg.authCache = map[[sha256.Size]byte]bool{}
+	return nil
+}
+
+// config returns the current web config, reloading it if changed since the
+// last check, which is done at most once per webConfigCheckInterval. Upon
+// reload failure, the previous valid config remains in effect
+func (wg *nfsgWebGuard) config() (*nfsgWebConfig, *tls.Config) {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	if wg.path == "" || time.Since(wg.checked) < webConfigCheckInterval {
+		return wg.cfg, wg.tlsCfg
+	}
+	wg.checked = time.Now()
+	if wg.changed() {
+		if err := wg.reload(); err != nil {
+			wg.nme.log.Error(err, "failed to reload web config", "path", wg.path)
+		} else {
+			wg.nme.log.Info("reloaded web config", "path", wg.path)
+		}
+	}
+	return wg.cfg, wg.tlsCfg
+}
+
+// newTLSConfig creates a server TLS config. As it is returned per handshake,
+// it also defines the ALPN protocols, by which HTTP/2 is negotiated
+func newTLSConfig(tc *nfsgTLSConfig, http2 bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"http/1.1"},
+	}
+	if http2 {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+	if tc.MinVersion != "" {
+		if cfg.MinVersion, err = parseTLSVersion(tc.MinVersion); err != nil {
+			return nil, err
+		}
+	}
+	if tc.MaxVersion != "" {
+		if cfg.MaxVersion, err = parseTLSVersion(tc.MaxVersion); err != nil {
+			return nil, err
+		}
+	}
+	if len(tc.CipherSuites) > 0 {
+		if cfg.CipherSuites, err = parseCipherSuites(tc.CipherSuites); err != nil {
+			return nil, err
+		}
+	}
+	if len(tc.CurvePreferences) > 0 {
+		if cfg.CurvePreferences, err = parseCurves(tc.CurvePreferences); err != nil {
+			return nil, err
+		}
+	}
+	cfg.PreferServerCipherSuites = tc.PreferServerCipherSuites
+	if tc.ClientCAFile != "" {
+		pem, err := os.ReadFile(tc.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", tc.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+	}
+	cfg.ClientAuth, err = parseClientAuthType(tc.ClientAuthType)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ClientAuth >= tls.VerifyClientCertIfGiven && cfg.ClientCAs == nil {
+		return nil, errors.New("client_ca_file required for client verification")
+	}
+	return cfg, nil
+}
+
+func parseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "TLS10":
+		return tls.VersionTLS10, nil
+	case "TLS11":
+		return tls.VersionTLS11, nil
+	case "TLS12":
+		return tls.VersionTLS12, nil
+	case "TLS13":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version: %s", s)
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	known := map[string]uint16{}
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	ids := []uint16{}
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func parseCurves(names []string) ([]tls.CurveID, error) {
+	known := map[string]tls.CurveID{
+		"CurveP256": tls.CurveP256,
+		"CurveP384": tls.CurveP384,
+		"CurveP521": tls.CurveP521,
+		"X25519":    tls.X25519,
+	}
+	ids := []tls.CurveID{}
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func parseClientAuthType(s string) (tls.ClientAuthType, error) {
+	switch s {
+	case "", "NoClientCert":
+		return tls.NoClientCert, nil
+	case "RequestClientCert":
+		return tls.RequestClientCert, nil
+	case "RequireAnyClientCert", "RequireClientCert":
+		return tls.RequireAnyClientCert, nil
+	case "VerifyClientCertIfGiven":
+		return tls.VerifyClientCertIfGiven, nil
+	case "RequireAndVerifyClientCert":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("unknown client_auth_type: %s", s)
+}
+
+// http2 returns false if HTTP/2 is disabled by web config
+func (wg *nfsgWebGuard) http2() bool {
+	cfg, _ := wg.config()
+	return cfg.HTTPConfig.HTTP2
+}
+
+// tlsConfig returns a server TLS config which re-evaluates the current web
+// config upon each handshake, or nil if TLS is not enabled
+func (wg *nfsgWebGuard) tlsConfig() *tls.Config {
+	_, tlsCfg := wg.config()
+	if tlsCfg == nil {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			_, cur := wg.config()
+			if cur == nil {
+				return nil, errors.New("TLS disabled by web config")
+			}
+			return cur, nil
+		},
+	}
+}
+
+var (
+	// authDummyHash is compared against upon unknown users, so that response
+	// time does not reveal which users exist
+	authDummyHash     []byte
+	authDummyHashOnce sync.Once
+)
+
+func dummyHash() []byte {
+	authDummyHashOnce.Do(func() {
+		authDummyHash, _ = bcrypt.GenerateFromPassword(
+			[]byte("nfs-ganesha-metrics"), bcrypt.DefaultCost)
+	})
+	return authDummyHash
+}
+
+// authenticate checks basic-auth credentials against bcrypt hashes, caching
+// successful checks since bcrypt is expensive by design
+func (wg *nfsgWebGuard) authenticate(cfg *nfsgWebConfig, user, pass string) bool {
+	hashed, ok := cfg.BasicAuthUsers[user]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(pass))
+		return false
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + pass + "\x00" + hashed))
+
+	wg.mu.Lock()
+	cached := wg.authCache[key]
+	wg.mu.Unlock()
+	if cached {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hashed), []byte(pass)) != nil {
+		return false
+	}
+	wg.mu.Lock()
+	wg.authCache[key] = true
+	wg.mu.Unlock()
+	return true
+}
+
+// wrap returns an HTTP handler which enforces basic authentication and
+// applies configured response headers. Paths in exempt are served without
+// authentication
+func (wg *nfsgWebGuard) wrap(next http.Handler, exempt ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg, _ := wg.config()
+		for k, v := range cfg.HTTPConfig.Headers {
+			w.Header().Set(k, v)
+		}
+		if len(cfg.BasicAuthUsers) == 0 || isExemptPath(r.URL.Path, exempt) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, pass, ok := r.BasicAuth()
+		if ok && wg.authenticate(cfg, user, pass) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="nfs-ganesha-metrics"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized),
+			http.StatusUnauthorized)
+	})
+}
+
+func isExemptPath(path string, exempt []string) bool {
+	for _, p := range exempt {
+		if path == p {
+			return true
+		}
+	}
+	return false
+}